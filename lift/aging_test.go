@@ -0,0 +1,87 @@
+package lift
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns the order of the car's first stop at each floor.
+func stopOrder(events *eventRecorder) map[Floor]int {
+	order := make(map[Floor]int)
+	for _, ev := range events.of(EventStop) {
+		if _, ok := order[ev.Floor]; !ok {
+			order[ev.Floor] = len(order)
+		}
+	}
+	return order
+}
+
+// A car going up to several floors passes a pickup the other way, to make on its way back: unless it has waited
+// longer than MaxWait, when the car turns back for it at its next stop.
+func TestAgedPickupMadeFirst(t *testing.T) {
+	for _, c := range []struct {
+		maxWait time.Duration
+		aged    bool
+	}{
+		{0, false},
+		{10 * time.Second, true},
+	} {
+		spec := DefaultCarSpec
+		spec.MaxWait = c.maxWait
+		e, events, p, close := testCar(10, spec)
+
+		done := doneChan(p)
+		if err := SendPickup(e, Pickup{Floor: 1, Dir: DOWN, Done: done}); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []Floor{2, 4, 6, 8} {
+			if err := SendDropoff(e, Dropoff{Floor: f, Done: make(chan Arrival, 1)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if arrival := awaitArrival(t, p, done, 0, "1 DOWN"); arrival.Aged != c.aged {
+			t.Errorf("MaxWait %v: the pickup was Aged %v, want %v", c.maxWait, arrival.Aged, c.aged)
+		}
+		p.Sleep(time.Minute)
+		close()
+
+		order := stopOrder(events)
+		if before := order[1] < order[8]; before != c.aged {
+			t.Errorf("MaxWait %v: the car stopped at floors in the order %v", c.maxWait, order)
+		}
+	}
+}
+
+// A hall call which outwaits its car's MaxWait is escalated to the car which can make it soonest.
+func TestHallCallEscalated(t *testing.T) {
+	specs := DefaultCarSpecs(2)
+	for i := range specs {
+		specs[i].MaxWait = 10 * time.Second
+	}
+	s, p, close := testSystem(10, specs)
+	defer close()
+
+	// Elevator-0 sets off up, with stops to make, and is sent the call; Elevator-1 is idle.
+	for _, f := range []Floor{2, 4, 6, 8} {
+		if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: f, Done: make(chan Arrival, 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 1, Dir: DOWN, Done: done}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{1, DOWN}); got != 0 {
+		t.Fatalf("1 DOWN went to Elevator-%d, want Elevator-0", got)
+	}
+	arrival := awaitArrival(t, p, done, 1, "1 DOWN")
+	if !arrival.Aged {
+		t.Errorf("the pickup was not Aged")
+	}
+	if at := s.clock.Now().Sub(Epoch); at < 10*time.Second {
+		t.Errorf("Elevator-1 made the pickup at %v, before it was overdue", at)
+	}
+	if stats := s.Aging(); stats != (AgingStats{Escalated: 1, AgedPickups: 1}) {
+		t.Errorf("got %+v, want one escalated and aged pickup", stats)
+	}
+}
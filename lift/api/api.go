@@ -0,0 +1,511 @@
+// Package api serves a running System over HTTP, with JSON bodies, so that tools in any language can
+// drive the simulator. lift/main runs it with the serve subcommand.
+//
+//	POST /hall-calls            {"floor": "L", "dir": "up", "dest": "7"}   A passenger calls a car (dest is optional).
+//	POST /cars/{id}/car-calls   {"floor": "7"}                             A passenger aboard car id presses a button.
+//	POST /cars/{id}/mode        {"mode": "maintenance"}                    Sets the mode of car id (see lift.CarMode).
+//	POST /cars/{id}/moves       {"floor": "3"}                             Moves car id, in maintenance (see lift.System.MoveCar).
+//	GET  /cars                                                             The status of every car.
+//	GET  /hall-calls                                                       The outstanding hall calls.
+//	GET  /building                                                         The floors, and how many cars.
+//	GET  /events                                                           Server-Sent Events, as they happen (below).
+//	GET  /metrics                                                          Metrics, in the Prometheus text format. See Metrics.
+//	GET  /snapshot                                                         The full state of the System, as a lift.Snapshot.
+//	GET  /                                                                 A live dashboard of the building.
+//
+// The event stream has three kinds of event: "arrival" (an Arrival, for every stop of every car), "car" (a Car,
+// whenever its status changes: it passes or reaches a floor, its doors move, or its requests change) and
+// "halls" (every HallCall, whenever they change). It starts with a "car" event for each car, and a "halls" event.
+//
+// Floors are numbers or labels (see lift.Building.ParseFloor), and directions "up" or "down". A hall call's
+// dest only rules out cars which do not serve it (see lift.Pickup): once aboard, the passenger makes a car call.
+// The POSTs of calls reply 202 Accepted at once; or with ?wait=true, 200 OK with the Arrival, once the call is
+// served. A mode change replies 200 OK with the Car, once made; a move 202 Accepted, as the car sets off, or 409
+// Conflict if it cannot (e.g., the car is not in maintenance). Once the System is closed, calls reply 503 Service
+// Unavailable.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server is an http.Handler for one System.
+type Server struct {
+	system   *lift.System
+	building *lift.Building
+	clock    lift.Clock
+	metrics  *Metrics
+	mux      *http.ServeMux
+}
+
+// A hall call, as POSTed.
+type HallCall struct {
+	Floor json.RawMessage `json:"floor"`
+	Dir   string          `json:"dir"`
+	Dest  json.RawMessage `json:"dest,omitempty"`
+}
+
+// A car call (or a move), as POSTed.
+type CarCall struct {
+	Floor json.RawMessage `json:"floor"`
+}
+
+// A mode change, as POSTed.
+type ModeChange struct {
+	Mode string `json:"mode"`
+}
+
+// A call, as acknowledged.
+type Call struct {
+	Car   int    `json:"car"` // -1 for a hall call: the System chooses the car.
+	Floor int    `json:"floor"`
+	Label string `json:"label"`
+	Dir   string `json:"dir,omitempty"`
+}
+
+// An Arrival, as sent to clients.
+type Arrival struct {
+	Time     time.Time `json:"time"`
+	Car      int       `json:"car"` // -1 if the System cancelled the call.
+	Floor    int       `json:"floor"`
+	Label    string    `json:"label"`
+	Dir      string    `json:"dir"`
+	Outcome  string    `json:"outcome"`
+	Alighted int       `json:"alighted"`
+	Load     int       `json:"load"`
+}
+
+// An outstanding hall call, as sent to clients.
+type Hall struct {
+	Floor   int       `json:"floor"`
+	Label   string    `json:"label"`
+	Dir     string    `json:"dir"`
+	Waiting int       `json:"waiting"` // Passengers (or groups).
+	Since   time.Time `json:"since"`
+	Car     int       `json:"car"` // -1 if no car could take it yet.
+}
+
+// The building, as sent to clients.
+type Building struct {
+	Name   string  `json:"name"`
+	Floors []Floor `json:"floors"` // From the bottom.
+	Cars   int     `json:"cars"`
+}
+
+type Floor struct {
+	Floor int    `json:"floor"`
+	Label string `json:"label"`
+}
+
+// The status of a car, as sent to clients.
+type Car struct {
+	Id          int      `json:"id"`
+	Floor       int      `json:"floor"`
+	Label       string   `json:"label"`
+	Dest        int      `json:"dest"`
+	Dir         string   `json:"dir"`
+	Doors       string   `json:"doors"`
+	Load        int      `json:"load"`
+	Dropoffs    []int    `json:"dropoffs"`
+	PickupsUp   []int    `json:"pickupsUp"`
+	PickupsDown []int    `json:"pickupsDown"`
+	Faults      []string `json:"faults,omitempty"` // See lift.FaultKind.
+	Mode        string   `json:"mode"`             // See lift.CarMode.
+}
+
+// The metrics must be the System's EventSink (or among them). If nil, there are no /metrics.
+func NewServer(system *lift.System, building *lift.Building, clock lift.Clock, metrics *Metrics) *Server {
+	s := &Server{system: system, building: building, clock: clock, metrics: metrics, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/hall-calls", s.handleHallCalls)
+	s.mux.HandleFunc("/cars", s.handleCars)
+	s.mux.HandleFunc("/cars/", s.handleCar)
+	s.mux.HandleFunc("/building", s.handleBuilding)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/snapshot", s.handleSnapshot)
+	if metrics != nil {
+		s.mux.HandleFunc("/metrics", s.handleMetrics)
+	}
+	s.mux.HandleFunc("/", s.handleDashboard)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+func (s *Server) handleHallCalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		halls := s.system.HallCalls()
+		if halls == nil {
+			httpError(w, http.StatusServiceUnavailable, "the system is closed")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.halls(halls))
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	var call HallCall
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		httpError(w, http.StatusBadRequest, "bad hall call: %v", err)
+		return
+	}
+	floor, err := s.building.ParseFloor(unquote(call.Floor))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	dir, err := parseDirection(call.Dir)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if (dir == lift.UP && int(floor) == s.building.Floors-1) || (dir == lift.DOWN && floor == 0) {
+		httpError(w, http.StatusBadRequest, "there is no going %s from floor %s", call.Dir, s.building.Label(floor))
+		return
+	}
+	pickup := lift.Pickup{Floor: floor, Dir: dir}
+	if len(call.Dest) > 0 {
+		dest, err := s.building.ParseFloor(unquote(call.Dest))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		pickup.Dests = []lift.Floor{dest}
+	}
+	done := make(chan lift.Arrival, 1) // Buffered: the System never waits for us.
+	pickup.Done = done
+	log.Printf("API: hall call %s %s\n", floor, dir)
+	if err := lift.SendPickup(s.system, pickup); err != nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	s.reply(w, r, Call{Car: -1, Floor: int(floor), Label: s.building.Label(floor), Dir: dir.String()}, done)
+}
+
+// Handles POST /cars/{id}/car-calls, /cars/{id}/mode and /cars/{id}/moves.
+func (s *Server) handleCar(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/cars/"), "/")
+	if len(parts) != 2 || (parts[1] != "car-calls" && parts[1] != "mode" && parts[1] != "moves") {
+		http.NotFound(w, r)
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	cars := s.system.Conveyors()
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id < 0 || id >= len(cars) {
+		httpError(w, http.StatusNotFound, "no car %q: there are %d", parts[0], len(cars))
+		return
+	}
+	switch parts[1] {
+	case "car-calls":
+		s.handleCarCall(w, r, id)
+	case "mode":
+		s.handleMode(w, r, id)
+	case "moves":
+		s.handleMove(w, r, id)
+	}
+}
+
+func (s *Server) handleCarCall(w http.ResponseWriter, r *http.Request, id int) {
+	cars := s.system.Conveyors()
+	var call CarCall
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		httpError(w, http.StatusBadRequest, "bad car call: %v", err)
+		return
+	}
+	floor, err := s.building.ParseFloor(unquote(call.Floor))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	done := make(chan lift.Arrival, 1)
+	log.Printf("API: car call %s in Elevator-%d\n", floor, id)
+	if err := lift.SendDropoff(cars[id], lift.Dropoff{Floor: floor, Done: done}); err != nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	s.reply(w, r, Call{Car: id, Floor: int(floor), Label: s.building.Label(floor)}, done)
+}
+
+func (s *Server) handleMode(w http.ResponseWriter, r *http.Request, id int) {
+	var change ModeChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		httpError(w, http.StatusBadRequest, "bad mode: %v", err)
+		return
+	}
+	mode := lift.CarMode(strings.ToLower(change.Mode))
+	if err := mode.Validate(); err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	log.Printf("API: Elevator-%d to %s mode\n", id, mode)
+	if err := s.system.SetCarMode(id, mode); err == lift.ErrClosed {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	statuses := s.system.Status()
+	if statuses == nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.car(statuses[id]))
+}
+
+func (s *Server) handleMove(w http.ResponseWriter, r *http.Request, id int) {
+	var move CarCall
+	if err := json.NewDecoder(r.Body).Decode(&move); err != nil {
+		httpError(w, http.StatusBadRequest, "bad move: %v", err)
+		return
+	}
+	floor, err := s.building.ParseFloor(unquote(move.Floor))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	log.Printf("API: move Elevator-%d to %s\n", id, floor)
+	if err := s.system.MoveCar(id, floor); err == lift.ErrClosed {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusConflict, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, Call{Car: id, Floor: int(floor), Label: s.building.Label(floor)})
+}
+
+// Acknowledges a call: at once, or (with ?wait=true) when its Arrival comes.
+func (s *Server) reply(w http.ResponseWriter, r *http.Request, call Call, done <-chan lift.Arrival) {
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); !wait {
+		writeJSON(w, http.StatusAccepted, call)
+		return
+	}
+	select {
+	case arrival := <-done:
+		writeJSON(w, http.StatusOK, s.arrival(arrival))
+	case <-r.Context().Done(): // The client gave up. The call stands.
+	}
+}
+
+func (s *Server) handleCars(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	statuses := s.system.Status()
+	if statuses == nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	cars := make([]Car, len(statuses))
+	for i, st := range statuses {
+		cars[i] = s.car(st)
+	}
+	writeJSON(w, http.StatusOK, cars)
+}
+
+func (s *Server) handleBuilding(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	b := Building{Name: s.building.Name, Cars: len(s.system.Conveyors())}
+	for f := lift.Floor(0); int(f) < s.building.Floors; f++ {
+		b.Floors = append(b.Floors, Floor{int(f), s.building.Label(f)})
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	snap := s.system.Snapshot()
+	if snap == nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	writeJSON(w, http.StatusOK, snap)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	cars, halls := s.system.Status(), s.system.HallCalls()
+	if cars == nil || halls == nil {
+		httpError(w, http.StatusServiceUnavailable, "the system is closed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w, s.building, cars, halls)
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+// Streams Arrivals, Cars and HallCalls as Server-Sent Events, until the client goes away. A client which falls
+// behind misses Arrivals (see lift.System.Watch) and Cars, though the next Car event of a car brings it up to date.
+// The System does not announce changes to its hall calls: we ask for them after every other event (they change
+// when a passenger calls or a car arrives, and so the assigned car's requests change too), and every second.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	arrivals := make(chan lift.Arrival, 64)
+	unwatch := s.system.Watch(arrivals)
+	defer unwatch()
+	cars := make(chan lift.CarStatus, 64)
+	unwatchCars := s.system.WatchCars(cars)
+	defer unwatchCars()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(event string, v interface{}) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("API: %v\n", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	var halls []Hall
+	sendHalls := func() bool {
+		statuses := s.system.HallCalls()
+		if statuses == nil {
+			return false // Closed.
+		}
+		if latest := s.halls(statuses); halls == nil || !reflect.DeepEqual(latest, halls) {
+			halls = latest
+			return send("halls", halls)
+		}
+		return true
+	}
+	ok = sendHalls()
+	for ok {
+		select {
+		case arrival := <-arrivals:
+			ok = send("arrival", s.arrival(arrival)) && sendHalls()
+		case status := <-cars:
+			ok = send("car", s.car(status)) && sendHalls()
+		case <-ticker.C:
+			ok = sendHalls()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) car(st lift.CarStatus) Car {
+	return Car{Id: st.Id, Floor: int(st.Floor), Label: s.building.Label(st.Floor), Dest: int(st.Dest),
+		Dir: st.Dir.String(), Doors: st.Doors.String(), Load: st.Load,
+		Dropoffs: ints(st.Dropoffs), PickupsUp: ints(st.PickupsUp), PickupsDown: ints(st.PickupsDown),
+		Faults: faults(st.Faults), Mode: string(st.Mode)}
+}
+
+func faults(kinds []lift.FaultKind) []string {
+	var names []string
+	for _, kind := range kinds {
+		names = append(names, string(kind))
+	}
+	return names
+}
+
+func (s *Server) halls(statuses []lift.HallCallStatus) []Hall {
+	halls := make([]Hall, len(statuses))
+	for i, st := range statuses {
+		halls[i] = Hall{Floor: int(st.Floor), Label: s.building.Label(st.Floor), Dir: st.Dir.String(),
+			Waiting: st.Waiting, Since: st.Since, Car: st.Car}
+	}
+	return halls
+}
+
+func (s *Server) arrival(a lift.Arrival) Arrival {
+	car := -1
+	if a.Conveyor != nil {
+		car = a.Conveyor.Id()
+	}
+	return Arrival{Time: s.clock.Now(), Car: car, Floor: int(a.Floor), Label: s.building.Label(a.Floor),
+		Dir: a.Dir.String(), Outcome: a.Outcome.String(), Alighted: a.Alighted, Load: a.Load}
+}
+
+func parseDirection(s string) (lift.Direction, error) {
+	switch strings.ToLower(s) {
+	case "up":
+		return lift.UP, nil
+	case "down":
+		return lift.DOWN, nil
+	default:
+		return lift.IDLE, fmt.Errorf("direction must be up or down, got %q", s)
+	}
+}
+
+func ints(floors []lift.Floor) []int {
+	ns := make([]int, len(floors))
+	for i, f := range floors {
+		ns[i] = int(f)
+	}
+	return ns
+}
+
+// Returns a JSON string's contents, or other JSON (e.g. a number) as is.
+func unquote(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// Returns true if the request uses the method. Otherwise, replies 405.
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	httpError(w, http.StatusMethodNotAllowed, "use %s", method)
+	return false
+}
+
+func httpError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeJSON(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API: %v\n", err)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"github.com/delliston/mygo/lift"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Once the System is closed, calls and queries reply 503 Service Unavailable, at once.
+func TestClosed(t *testing.T) {
+	building := lift.NewBuilding(5, 2)
+	clock := lift.NewVirtualClock(lift.Epoch)
+	defer clock.Stop()
+	metrics := NewMetrics()
+	s, err := building.NewSystem(clock, 1, metrics, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(s, building, clock, metrics))
+	defer server.Close()
+	s.Close()
+
+	for _, call := range []struct{ path, body string }{
+		{"/hall-calls?wait=true", `{"floor": "0", "dir": "up"}`},
+		{"/cars/1/car-calls?wait=true", `{"floor": "3"}`},
+		{"/cars/0/mode", `{"mode": "maintenance"}`},
+		{"/cars/0/moves", `{"floor": "2"}`},
+	} {
+		postJSON(t, server.URL+call.path, call.body, http.StatusServiceUnavailable)
+	}
+	for _, path := range []string{"/cars", "/hall-calls", "/snapshot", "/metrics"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: %s, want 503: %s", path, resp.Status, msg)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+// The dashboard page, served at /. It draws the building from GET /building, then animates it from GET /events:
+// one shaft per car, with its car (direction, doors and load) and lit car buttons (dropoffs); and beside the
+// shafts, the lit hall buttons and how many passengers wait at each. Clicking a hall button calls a car; clicking
+// a floor in a shaft presses that car's button for it.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>lift</title>
+<style>
+body { font: 13px sans-serif; margin: 16px; color: #222; background: #fafafa; }
+h1 { font-size: 16px; }
+#main { display: flex; align-items: flex-start; }
+#building { display: flex; align-items: flex-start; margin-right: 24px; }
+.column { position: relative; margin-right: 4px; }
+.row { height: 28px; box-sizing: border-box; border-bottom: 1px solid #ddd; display: flex; align-items: center; }
+.head { height: 20px; text-align: center; font-weight: bold; }
+.label { width: 40px; justify-content: flex-end; padding-right: 6px; font-weight: bold; }
+.hall { width: 20px; justify-content: center; color: #bbb; cursor: pointer; user-select: none; }
+.hall.lit { color: #e70; }
+.waiting { width: 28px; color: #555; }
+.shaft { width: 64px; background: #eee; }
+.shaft .row { justify-content: center; cursor: pointer; color: transparent; }
+.shaft .row.lit { color: #e70; }
+.car { position: absolute; left: 3px; width: 58px; height: 26px; box-sizing: border-box; border: 2px solid #345;
+	background: #cde; display: flex; align-items: center; justify-content: space-between; padding: 0 4px;
+	transition: top 0.8s linear; pointer-events: none; }
+.car.OPENING, .car.CLOSING { background: #dec; }
+.car.OPEN { background: #9d9; border-color: #363; }
+#log { font-family: monospace; white-space: pre; color: #444; }
+#status { color: #a00; }
+</style>
+</head>
+<body>
+<h1 id="name">lift</h1>
+<div id="status"></div>
+<div id="main">
+<div id="building"></div>
+<div id="log"></div>
+</div>
+<script>
+"use strict";
+const ROW = 28, HEAD = 20;
+const arrows = {UP: "▲", DOWN: "▼", IDLE: "•"};
+let building, cars = [], floorEls = {}, carEls = [], shaftRows = [];
+
+function el(tag, cls, text) {
+	const e = document.createElement(tag);
+	if (cls) e.className = cls;
+	if (text !== undefined) e.textContent = text;
+	return e;
+}
+
+function post(url, body) {
+	fetch(url, {method: "POST", body: JSON.stringify(body)})
+		.then(r => r.ok ? null : r.json().then(e => { document.getElementById("status").textContent = e.error; }));
+}
+
+function draw() {
+	document.getElementById("name").textContent = building.name;
+	const root = document.getElementById("building");
+	const halls = el("div", "column");
+	halls.appendChild(el("div", "head"));
+	const top = building.floors.length - 1;
+	for (const f of building.floors.slice().reverse()) {
+		const row = el("div", "row");
+		row.appendChild(el("div", "label", f.label));
+		const up = el("div", "hall", f.floor < top ? arrows.UP : "");
+		const down = el("div", "hall", f.floor > 0 ? arrows.DOWN : "");
+		if (f.floor < top) up.onclick = () => post("/hall-calls", {floor: f.floor, dir: "up"});
+		if (f.floor > 0) down.onclick = () => post("/hall-calls", {floor: f.floor, dir: "down"});
+		const waiting = el("div", "waiting");
+		row.append(up, down, waiting);
+		halls.appendChild(row);
+		floorEls[f.floor] = {UP: up, DOWN: down, waiting: waiting};
+	}
+	root.appendChild(halls);
+	for (let id = 0; id < building.cars; id++) {
+		const shaft = el("div", "column shaft");
+		shaft.appendChild(el("div", "head", "Car " + id));
+		shaftRows[id] = {};
+		for (const f of building.floors.slice().reverse()) {
+			const row = el("div", "row", "●");
+			row.title = "Car " + id + " to " + f.label;
+			row.onclick = () => post("/cars/" + id + "/car-calls", {floor: f.floor});
+			shaft.appendChild(row);
+			shaftRows[id][f.floor] = row;
+		}
+		const car = el("div", "car");
+		car.style.top = topOf(0) + "px";
+		shaft.appendChild(car);
+		carEls[id] = car;
+		root.appendChild(shaft);
+	}
+}
+
+// Floors are drawn from the top.
+function topOf(floor) { return HEAD + (building.floors.length - 1 - floor) * ROW + 1; }
+
+function onCar(car) {
+	cars[car.id] = car;
+	const e = carEls[car.id];
+	e.className = "car " + car.doors;
+	e.style.top = topOf(car.floor) + "px";
+	e.textContent = "";
+	e.append(el("span", "", arrows[car.dir]), el("span", "", car.load));
+	e.title = car.doors + ", going " + car.dir + " to " + building.floors[car.dest].label;
+	const dropoffs = new Set(car.dropoffs);
+	for (const f in shaftRows[car.id]) {
+		shaftRows[car.id][f].classList.toggle("lit", dropoffs.has(Number(f)));
+	}
+}
+
+function onHalls(halls) {
+	for (const f in floorEls) {
+		floorEls[f].UP.classList.remove("lit");
+		floorEls[f].DOWN.classList.remove("lit");
+		floorEls[f].waiting.textContent = "";
+	}
+	const waiting = {};
+	for (const h of halls) {
+		floorEls[h.floor][h.dir].classList.add("lit");
+		waiting[h.floor] = (waiting[h.floor] || 0) + h.waiting;
+	}
+	for (const f in waiting) {
+		floorEls[f].waiting.textContent = waiting[f] > 0 ? waiting[f] : "";
+	}
+}
+
+const logLines = [];
+function onArrival(a) {
+	if (a.car < 0) return;
+	const t = new Date(a.time).toLocaleTimeString();
+	logLines.unshift(t + "  car " + a.car + " at " + a.label + " " + a.dir.padEnd(4) + " " + a.outcome +
+		(a.alighted > 0 ? ", " + a.alighted + " out" : "") + ", load " + a.load);
+	logLines.length = Math.min(logLines.length, 30);
+	document.getElementById("log").textContent = logLines.join("\n");
+}
+
+fetch("/building").then(r => r.json()).then(b => {
+	building = b;
+	draw();
+	const events = new EventSource("/events");
+	events.addEventListener("car", e => onCar(JSON.parse(e.data)));
+	events.addEventListener("halls", e => onHalls(JSON.parse(e.data)));
+	events.addEventListener("arrival", e => onArrival(JSON.parse(e.data)));
+	events.onopen = () => { document.getElementById("status").textContent = ""; };
+	events.onerror = () => { document.getElementById("status").textContent = "Disconnected: reconnecting..."; };
+});
+</script>
+</body>
+</html>
+`
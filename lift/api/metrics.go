@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the buckets of the hall call wait histogram, in seconds.
+var waitBuckets = []float64{5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300}
+
+// Metrics is an EventSink which counts what the cars do, and how long hall calls wait, for GET /metrics
+// (in the Prometheus text format). Pass it to lift.NewSystem, and then to NewServer.
+type Metrics struct {
+	mu     sync.Mutex
+	cars   []carMetrics
+	calls  map[int64]time.Time // When each outstanding hall call was made, by call id.
+	waits  []int64             // How many waits fell in each bucket, and (last) how many were longer.
+	sum    time.Duration       // Of all waits.
+	served int64
+}
+
+type carMetrics struct {
+	floors, stops, doorCycles, reversals, faults int64
+	dir                                          string // The direction the car last travelled in.
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{calls: make(map[int64]time.Time), waits: make([]int64, len(waitBuckets)+1)}
+}
+
+func (m *Metrics) Event(ev lift.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var car *carMetrics
+	if ev.Car >= 0 {
+		for len(m.cars) <= ev.Car {
+			m.cars = append(m.cars, carMetrics{})
+		}
+		car = &m.cars[ev.Car]
+	}
+	switch ev.Kind {
+	case lift.EventHallCall:
+		if _, ok := m.calls[ev.Call]; !ok {
+			m.calls[ev.Call] = ev.Time // Later callers join the call: its wait counts from the first.
+		}
+	case lift.EventServed: // Whichever car made the pickup.
+		if since, ok := m.calls[ev.Call]; ok {
+			delete(m.calls, ev.Call)
+			m.observeWait(ev.Time.Sub(since))
+		}
+	case lift.EventCancellation:
+		if ev.Car < 0 {
+			delete(m.calls, ev.Call)
+		}
+	case lift.EventDeparture:
+		if car.dir != "" && car.dir != ev.Dir {
+			car.reversals++
+		}
+		car.dir = ev.Dir
+	case lift.EventPassFloor:
+		car.floors++
+	case lift.EventStop:
+		car.floors++
+		car.stops++
+	case lift.EventDoorsOpening:
+		car.doorCycles++
+	case lift.EventFault:
+		car.faults++
+	}
+}
+
+func (m *Metrics) observeWait(wait time.Duration) {
+	i := 0
+	for i < len(waitBuckets) && wait.Seconds() > waitBuckets[i] {
+		i++
+	}
+	m.waits[i]++
+	m.sum += wait
+	m.served++
+}
+
+// Writes the metrics, with the cars' current floors and loads, and the passengers waiting at each floor for
+// outstanding hall calls.
+func (m *Metrics) write(w io.Writer, building *lift.Building, cars []lift.CarStatus, halls []lift.HallCallStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters := make([]carMetrics, len(cars))
+	copy(counters, m.cars)
+
+	var b strings.Builder
+	family := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	perCar := func(name, kind, help string, value func(i int) int64) {
+		family(name, kind, help)
+		for i, car := range cars {
+			fmt.Fprintf(&b, "%s{car=\"%d\"} %d\n", name, car.Id, value(i))
+		}
+	}
+	perCar("lift_car_floors_travelled_total", "counter", "Floors each car has travelled.",
+		func(i int) int64 { return counters[i].floors })
+	perCar("lift_car_stops_total", "counter", "Stops each car has made.",
+		func(i int) int64 { return counters[i].stops })
+	perCar("lift_car_door_cycles_total", "counter", "Times each car's doors have opened (reopening included).",
+		func(i int) int64 { return counters[i].doorCycles })
+	perCar("lift_car_direction_reversals_total", "counter", "Times each car has set off the other way from its last trip.",
+		func(i int) int64 { return counters[i].reversals })
+	perCar("lift_car_faults_total", "counter", "Faults each car has detected.",
+		func(i int) int64 { return counters[i].faults })
+	perCar("lift_car_floor", "gauge", "The floor each car is at, or last passed.",
+		func(i int) int64 { return int64(cars[i].Floor) })
+	perCar("lift_car_load", "gauge", "Passengers aboard each car.",
+		func(i int) int64 { return int64(cars[i].Load) })
+	perCar("lift_car_faulted", "gauge", "Faults each car has now, detected and not yet cleared.",
+		func(i int) int64 { return int64(len(cars[i].Faults)) })
+	family("lift_car_mode", "gauge", "Each car's service mode: 1 for the mode it is in, 0 for the others.")
+	for _, car := range cars {
+		for _, mode := range lift.CarModes {
+			in := 0
+			if car.Mode == mode {
+				in = 1
+			}
+			fmt.Fprintf(&b, "lift_car_mode{car=\"%d\",mode=\"%s\"} %d\n", car.Id, mode, in)
+		}
+	}
+
+	family("lift_hall_call_wait_seconds", "histogram", "How long hall calls waited for a car, from the first call.")
+	cumulative := int64(0)
+	for i, le := range waitBuckets {
+		cumulative += m.waits[i]
+		fmt.Fprintf(&b, "lift_hall_call_wait_seconds_bucket{le=\"%g\"} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(&b, "lift_hall_call_wait_seconds_bucket{le=\"+Inf\"} %d\n", m.served)
+	fmt.Fprintf(&b, "lift_hall_call_wait_seconds_sum %g\n", m.sum.Seconds())
+	fmt.Fprintf(&b, "lift_hall_call_wait_seconds_count %d\n", m.served)
+
+	waiting := make(map[hallKey]int)
+	for _, h := range halls {
+		waiting[hallKey{h.Floor, h.Dir}] = h.Waiting
+	}
+	family("lift_pending_pickups", "gauge", "Passengers waiting at each floor, by direction, for an outstanding hall call.")
+	for f := lift.Floor(0); int(f) < building.Floors; f++ {
+		for _, dir := range []lift.Direction{lift.UP, lift.DOWN} {
+			if (dir == lift.UP && int(f) == building.Floors-1) || (dir == lift.DOWN && f == 0) {
+				continue
+			}
+			fmt.Fprintf(&b, "lift_pending_pickups{floor=\"%d\",label=\"%s\",dir=\"%s\"} %d\n", f, labelEscaper.Replace(building.Label(f)),
+				strings.ToLower(dir.String()), waiting[hallKey{f, dir}])
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type hallKey struct {
+	floor lift.Floor
+	dir   lift.Direction
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serves a System on a VirtualClock, makes a few calls, and checks GET /metrics.
+func TestMetrics(t *testing.T) {
+	building := lift.NewBuilding(5, 2)
+	clock := lift.NewVirtualClock(lift.Epoch)
+	defer clock.Stop()
+	metrics := NewMetrics()
+	s, err := building.NewSystem(clock, 1, metrics, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	server := httptest.NewServer(NewServer(s, building, clock, metrics))
+	defer server.Close()
+
+	post := func(path, body string, want int) { postJSON(t, server.URL+path, body, want) }
+	// Two hall calls, each served before the reply.
+	post("/hall-calls?wait=true", `{"floor": "0", "dir": "up"}`, http.StatusOK)
+	post("/hall-calls?wait=true", `{"floor": "4", "dir": "down"}`, http.StatusOK)
+	// With both cars out of service, a third waits.
+	post("/cars/0/mode", `{"mode": "out-of-service"}`, http.StatusOK)
+	post("/cars/1/mode", `{"mode": "out-of-service"}`, http.StatusOK)
+	post("/hall-calls", `{"floor": "2", "dir": "up"}`, http.StatusAccepted)
+
+	samples := getMetrics(t, server.URL)
+	for _, want := range []struct{ sample, value string }{
+		{`lift_hall_call_wait_seconds_bucket{le="60"}`, "2"},
+		{`lift_hall_call_wait_seconds_bucket{le="+Inf"}`, "2"},
+		{`lift_hall_call_wait_seconds_count`, "2"},
+		{`lift_pending_pickups{floor="2",label="2",dir="up"}`, "1"},
+		{`lift_pending_pickups{floor="0",label="0",dir="up"}`, "0"},
+		{`lift_pending_pickups{floor="4",label="4",dir="down"}`, "0"},
+		{`lift_car_mode{car="0",mode="out-of-service"}`, "1"},
+		{`lift_car_mode{car="1",mode="normal"}`, "0"},
+	} {
+		if got, ok := samples[want.sample]; !ok || got != want.value {
+			t.Errorf("%s is %q, want %q", want.sample, got, want.value)
+		}
+	}
+	// A car opened its doors at 0, where it was idle; another travelled to 4, and stopped there.
+	var stops, doorCycles, floors int
+	for car := 0; car < 2; car++ {
+		stops += atoi(t, samples[fmt.Sprintf(`lift_car_stops_total{car="%d"}`, car)])
+		doorCycles += atoi(t, samples[fmt.Sprintf(`lift_car_door_cycles_total{car="%d"}`, car)])
+		floors += atoi(t, samples[fmt.Sprintf(`lift_car_floors_travelled_total{car="%d"}`, car)])
+	}
+	if stops != 1 || doorCycles != 2 || floors != 4 {
+		t.Errorf("%d stops, %d door cycles, %d floors travelled: want 1, 2 and 4", stops, doorCycles, floors)
+	}
+}
+
+// Chooses the first car which does not bypass the pickup, so that tests know which car takes it.
+type firstCar struct{}
+
+func (firstCar) Dispatch(pickup lift.Pickup, estimates []lift.PickupEstimate) lift.Conveyor {
+	return estimates[0].Conveyor
+}
+
+// A car the hall call was not sent to makes the pickup: the call's wait counts, and it is no longer pending.
+func TestMetricsServedByOtherCar(t *testing.T) {
+	building := lift.NewBuilding(6, 2)
+	clock := lift.NewVirtualClock(lift.Epoch)
+	defer clock.Stop()
+	metrics := NewMetrics()
+	s := lift.NewSystem(building.Floors, building.CarSpecs(), firstCar{}, clock, metrics, nil)
+	defer s.Close()
+	server := httptest.NewServer(NewServer(s, building, clock, metrics))
+	defer server.Close()
+
+	// Car 1 sets off for 3 and 5. As it nears 3, a hall call there goes to car 0, at 0: car 1 makes it, and car 0
+	// (which has barely left) stops short, so only car 1 arrives at 3. We take part in the simulation, so that the
+	// clock waits for our calls; and do not wait on the server for the Arrival, which would hold it still.
+	part := lift.Join(clock)
+	defer part.Leave()
+	postJSON(t, server.URL+"/cars/1/car-calls", `{"floor": "3"}`, http.StatusAccepted)
+	postJSON(t, server.URL+"/cars/1/car-calls", `{"floor": "5"}`, http.StatusAccepted)
+	part.Sleep(6 * time.Second)
+	postJSON(t, server.URL+"/hall-calls", `{"floor": "3", "dir": "up"}`, http.StatusAccepted)
+	part.Sleep(time.Minute)
+
+	samples := getMetrics(t, server.URL)
+	for _, want := range []struct{ sample, value string }{
+		{`lift_hall_call_wait_seconds_count`, "1"},
+		{`lift_pending_pickups{floor="3",label="3",dir="up"}`, "0"},
+		{`lift_car_stops_total{car="1"}`, "2"},
+	} {
+		if got := samples[want.sample]; got != want.value {
+			t.Errorf("%s is %q, want %q", want.sample, got, want.value)
+		}
+	}
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if len(metrics.calls) != 0 {
+		t.Errorf("Metrics holds %d calls, want none", len(metrics.calls))
+	}
+}
+
+func postJSON(t *testing.T, url, body string, want int) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST %s %s: %s, want %d: %s", url, body, resp.Status, want, msg)
+	}
+}
+
+// Returns the samples of GET /metrics: their values, by name and labels.
+func getMetrics(t *testing.T, url string) map[string]string {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: %s", resp.Status)
+	}
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.LastIndexByte(line, ' '); i > 0 && !strings.HasPrefix(line, "#") {
+			samples[line[:i]] = line[i+1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func atoi(t *testing.T, s string) int {
+	t.Helper()
+	var n int
+	if _, err := fmt.Sscan(s, &n); err != nil {
+		t.Fatalf("bad sample value %q: %v", s, err)
+	}
+	return n
+}
@@ -0,0 +1,275 @@
+package lift
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Building describes a building's floors and cars, as checked in (JSON) data. See LoadBuilding,
+// and the examples in lift/buildings. Omitted fields take the defaults of DefaultCarSpec.
+type Building struct {
+	Name         string      `json:"name"`
+	Floors       int         `json:"floors"`
+	FloorLabels  []string    `json:"floorLabels,omitempty"`  // One per floor, from the bottom. Default: the floor number.
+	FloorHeight  float64     `json:"floorHeight,omitempty"`  // Metres. Default: DefaultFloorHeight.
+	FloorHeights []float64   `json:"floorHeights,omitempty"` // Of each storey, from the bottom (one fewer than floors). Default: FloorHeight.
+	Dispatcher   string      `json:"dispatcher,omitempty"`   // See NewDispatcher. Default: "eta".
+	Doors        *DoorConfig `json:"doors,omitempty"`        // Default for every car.
+	MaxWait      Duration    `json:"maxWait,omitempty"`      // Default for every car. See CarSpec.MaxWait. Default: no limit.
+	Cars         []CarConfig `json:"cars"`
+}
+
+// One car (or Count identical cars) of a Building.
+type CarConfig struct {
+	Count        int         `json:"count,omitempty"`        // Default: 1.
+	Speed        float64     `json:"speed,omitempty"`        // Rated speed, in metres per second. See MotionProfile.
+	Acceleration float64     `json:"acceleration,omitempty"` // m/s². Default: DefaultMotion's.
+	Jerk         float64     `json:"jerk,omitempty"`         // m/s³. Default: DefaultMotion's.
+	Capacity     *Capacity   `json:"capacity,omitempty"`     // Default: DefaultCapacity.
+	BypassLoad   float64     `json:"bypassLoad,omitempty"`   // Fraction of capacity. Default: DefaultBypassLoad.
+	ServedFloors []Floor     `json:"servedFloors,omitempty"` // Default: all floors.
+	StopPolicy   string      `json:"stopPolicy,omitempty"`   // See NewStopPolicy. Default: "collective".
+	Doors        *DoorConfig `json:"doors,omitempty"`        // Default: the building's.
+	MaxWait      Duration    `json:"maxWait,omitempty"`      // Default: the building's.
+}
+
+// DoorTimes, as written in JSON. Omitted times take the default.
+type DoorConfig struct {
+	Opening   Duration `json:"opening,omitempty"`
+	Dwell     Duration `json:"dwell,omitempty"`
+	Closing   Duration `json:"closing,omitempty"`
+	Extension Duration `json:"extension,omitempty"`
+}
+
+// A time.Duration which is written in JSON as a string such as "1.5s", or a number of seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		secs, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return fmt.Errorf("duration must be a string like \"1.5s\" or a number of seconds, got %s", b)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Returns a Building of numFloors, with numCars default cars.
+func NewBuilding(numFloors, numCars int) *Building {
+	return &Building{Name: fmt.Sprintf("%d floors, %d cars", numFloors, numCars), Floors: numFloors,
+		Cars: []CarConfig{{Count: numCars}}}
+}
+
+// Reads and validates a Building from a JSON file.
+func LoadBuilding(path string) (*Building, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Building
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := b.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &b, nil
+}
+
+func (b *Building) Validate() error {
+	if b.Floors < 2 {
+		return fmt.Errorf("building needs at least 2 floors, has %d", b.Floors)
+	}
+	if b.FloorLabels != nil && len(b.FloorLabels) != b.Floors {
+		return fmt.Errorf("building has %d floors but %d floor labels", b.Floors, len(b.FloorLabels))
+	}
+	if b.MaxWait < 0 {
+		return fmt.Errorf("max wait must be positive, is %v", time.Duration(b.MaxWait))
+	}
+	if b.FloorHeight < 0 {
+		return fmt.Errorf("floor height must be positive, is %v", b.FloorHeight)
+	}
+	if b.FloorHeights != nil && len(b.FloorHeights) != b.Floors-1 {
+		return fmt.Errorf("building has %d floors, so needs %d floor heights, has %d", b.Floors, b.Floors-1, len(b.FloorHeights))
+	}
+	for i, h := range b.FloorHeights {
+		if h <= 0 {
+			return fmt.Errorf("floor height %d must be positive, is %v", i, h)
+		}
+	}
+	if len(b.CarSpecs()) == 0 {
+		return fmt.Errorf("building has no cars")
+	}
+	for i, car := range b.Cars {
+		if car.Count < 0 || car.Speed < 0 || car.Acceleration < 0 || car.Jerk < 0 || car.BypassLoad < 0 || car.BypassLoad > 1 || car.MaxWait < 0 {
+			return fmt.Errorf("car %d: count, speed, acceleration, jerk, bypass load and max wait must be positive (bypass load at most 1)", i)
+		}
+		for _, f := range car.ServedFloors {
+			if f < 0 || int(f) >= b.Floors {
+				return fmt.Errorf("car %d: served floor %d is not in the building", i, f)
+			}
+		}
+		if _, err := NewStopPolicy(car.StopPolicy); err != nil {
+			return fmt.Errorf("car %d: %v", i, err)
+		}
+	}
+	if _, err := NewDispatcher(b.Dispatcher, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns the label of the floor, e.g. for display.
+func (b *Building) Label(f Floor) string {
+	if int(f) < len(b.FloorLabels) && f >= 0 {
+		return b.FloorLabels[f]
+	}
+	return f.String()
+}
+
+// Returns the height of each floor, in metres from the bottom floor.
+func (b *Building) Levels() []float64 {
+	floorHeight := b.FloorHeight
+	if floorHeight == 0 {
+		floorHeight = DefaultFloorHeight
+	}
+	levels := make([]float64, b.Floors)
+	for i := 1; i < b.Floors; i++ {
+		height := floorHeight
+		if i-1 < len(b.FloorHeights) {
+			height = b.FloorHeights[i-1]
+		}
+		levels[i] = levels[i-1] + height
+	}
+	return levels
+}
+
+// Returns one CarSpec per car, in id order.
+func (b *Building) CarSpecs() []CarSpec {
+	levels := b.Levels()
+	var specs []CarSpec
+	for _, car := range b.Cars {
+		spec := DefaultCarSpec
+		spec.Levels = levels
+		if car.Speed > 0 {
+			spec.Motion.Speed = car.Speed
+		}
+		if car.Acceleration > 0 {
+			spec.Motion.Acceleration = car.Acceleration
+		}
+		if car.Jerk > 0 {
+			spec.Motion.Jerk = car.Jerk
+		}
+		if car.Capacity != nil {
+			spec.Capacity = *car.Capacity
+		}
+		if car.BypassLoad > 0 {
+			spec.BypassLoad = car.BypassLoad
+		}
+		spec.ServedFloors = car.ServedFloors
+		spec.MaxWait = time.Duration(b.MaxWait)
+		if car.MaxWait > 0 {
+			spec.MaxWait = time.Duration(car.MaxWait)
+		}
+		spec.StopPolicy, _ = NewStopPolicy(car.StopPolicy) // Checked by Validate.
+		spec.Doors = b.Doors.apply(spec.Doors)
+		spec.Doors = car.Doors.apply(spec.Doors)
+		count := car.Count
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// Returns how many persons (at AveragePassengerKg each) fit in the largest car, empty; or 0 if a car has no limit.
+func (b *Building) MaxGroup() int {
+	largest := 0
+	for _, spec := range b.CarSpecs() {
+		persons := spec.Capacity.Persons
+		if kg := spec.Capacity.Kg; kg > 0 && (persons == 0 || kg/AveragePassengerKg < persons) {
+			persons = kg / AveragePassengerKg
+		}
+		if persons == 0 {
+			return 0
+		}
+		if persons > largest {
+			largest = persons
+		}
+	}
+	return largest
+}
+
+// Returns times, overridden by those in dc.
+func (dc *DoorConfig) apply(times DoorTimes) DoorTimes {
+	if dc == nil {
+		return times
+	}
+	for _, field := range []struct {
+		from Duration
+		to   *time.Duration
+	}{{dc.Opening, &times.Opening}, {dc.Dwell, &times.Dwell}, {dc.Closing, &times.Closing}, {dc.Extension, &times.Extension}} {
+		if field.from > 0 {
+			*field.to = time.Duration(field.from)
+		}
+	}
+	return times
+}
+
+// Creates a System for the Building, with its Dispatcher. The seed is used by the random dispatcher.
+// The System sends its Events to events, and records its requests in the journal, unless these are nil.
+func (b *Building) NewSystem(clock Clock, seed int64, events EventSink, journal *Journal) (*System, error) {
+	dispatcher, err := b.dispatcher(seed)
+	if err != nil {
+		return nil, err
+	}
+	return NewSystem(b.Floors, b.CarSpecs(), dispatcher, clock, events, journal), nil
+}
+
+// Creates a System for the Building (as NewSystem) in the state of the Snapshot. See NewSystemFromSnapshot.
+func (b *Building) NewSystemFromSnapshot(snap *Snapshot, clock Clock, seed int64, events EventSink, journal *Journal,
+	listeners func(id string) chan<- Arrival) (*System, error) {
+	dispatcher, err := b.dispatcher(seed)
+	if err != nil {
+		return nil, err
+	}
+	return NewSystemFromSnapshot(snap, b.Floors, b.CarSpecs(), dispatcher, clock, events, journal, listeners)
+}
+
+func (b *Building) dispatcher(seed int64) (Dispatcher, error) {
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return NewDispatcher(b.Dispatcher, seed)
+}
+
+// Parses a floor, given by its label or number, e.g. in a trace.
+func (b *Building) ParseFloor(s string) (Floor, error) {
+	s = strings.TrimSpace(s)
+	for i, label := range b.FloorLabels {
+		if label == s {
+			return Floor(i), nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= b.Floors {
+		return InvalidFloor, fmt.Errorf("floor %q is not in the building", s)
+	}
+	return Floor(n), nil
+}
@@ -0,0 +1,404 @@
+package lift
+
+import (
+	"container/heap"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and wakes up sleepers. A System, its Elevators and drivers (and the passengers of a
+// simulation) should all share one Clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time // Like time.After
+	Sleep(d time.Duration)                  // Like time.Sleep
+}
+
+// RealClock is the wall clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// VirtualClock is a discrete-event clock. Time stands still while any Participant has work to do.
+// When every participant is idle (waiting for a timer, or a request), time jumps to the earliest
+// pending timer, and only that timer fires. So a simulation runs as fast as the CPU allows, and
+// gives the same results on every run. Goroutines which do not take part (e.g. a web server) do not
+// hold time up: their requests take effect whenever they arrive.
+//
+// Timers with the same deadline fire in the order they were created.
+//
+// For watching a simulation (see lift/tui), the clock can be paced to run at some multiple of real time
+// (SetSpeed), paused, and stepped. Pacing changes when timers fire in real time, never their order.
+type VirtualClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond // Signalled when a timer is added, or the pacing changes.
+	now     time.Time
+	timers  timerHeap
+	nextSeq int
+	stopped bool
+	done    chan struct{} // Closed when mainLoop returns.
+
+	// Participants.
+	busy    int                               // The participants running, plus the requests on their way to them.
+	awaited map[<-chan time.Time]*Participant // The timers idle participants wait for (see Participant.Idle).
+
+	// Pacing.
+	speed         float64       // Virtual time per real time. Zero means as fast as possible.
+	paused        bool          // If so, only timers due by stepTo fire.
+	stepTo        time.Time     // See Step.
+	anchorReal    time.Time     // When the virtual time was anchorVirtual. Reset when the pacing changes.
+	anchorVirtual time.Time     //
+	wake          chan struct{} // Interrupts mainLoop's wait for a paced timer.
+}
+
+// Epoch is the start time of a VirtualClock, unless specified otherwise.
+var Epoch = time.Date(2015, time.April, 27, 0, 0, 0, 0, time.UTC)
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := &VirtualClock{now: start, done: make(chan struct{}), wake: make(chan struct{}, 1),
+		awaited: make(map[<-chan time.Time]*Participant)}
+	c.cond = sync.NewCond(&c.mu)
+	go c.mainLoop()
+	return c
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1) // Buffered: firing never blocks, even if nobody listens any more.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	heap.Push(&c.timers, &virtualTimer{c.now.Add(d), c.nextSeq, ch})
+	c.nextSeq++
+	c.cond.Signal()
+	return ch
+}
+
+func (c *VirtualClock) Sleep(d time.Duration) { <-c.After(d) }
+
+// Stops the clock's goroutine, and waits until it has returned. Pending timers never fire.
+// Stop the System (see System.Close) first.
+func (c *VirtualClock) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	c.repace()
+	c.mu.Unlock()
+	<-c.done
+}
+
+// Runs the clock at speed times real time (e.g. 2 is twice as fast). Zero (the default) means as fast as possible.
+func (c *VirtualClock) SetSpeed(speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.speed = speed
+	c.repace()
+}
+
+func (c *VirtualClock) Speed() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed
+}
+
+// Stops time: no timer fires until Resume (or Step).
+func (c *VirtualClock) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused, c.stepTo = true, c.now
+	c.repace()
+}
+
+func (c *VirtualClock) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.repace()
+}
+
+func (c *VirtualClock) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// If the clock is paused, lets it run on by d (at its speed): the timers due by then fire. Otherwise, does nothing.
+func (c *VirtualClock) Step(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		if c.stepTo.Before(c.now) {
+			c.stepTo = c.now
+		}
+		c.stepTo = c.stepTo.Add(d)
+		c.repace()
+	}
+}
+
+// The pacing has changed: measure real time from now, and wake mainLoop to reconsider its next timer.
+// Call with c.mu held.
+func (c *VirtualClock) repace() {
+	c.anchorReal, c.anchorVirtual = time.Now(), c.now
+	c.cond.Signal()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Returns true if the earliest timer may fire now, pacing aside. Call with c.mu held.
+func (c *VirtualClock) ready() bool {
+	return len(c.timers) > 0 && !(c.paused && c.timers[0].deadline.After(c.stepTo))
+}
+
+// Returns how long (in real time) to wait before the virtual time reaches deadline. Call with c.mu held.
+// We do not catch up: if the simulation has fallen behind real time, the pace counts from now.
+func (c *VirtualClock) realWait(deadline time.Time) time.Duration {
+	if c.speed <= 0 {
+		return 0
+	}
+	due := c.anchorReal.Add(time.Duration(float64(deadline.Sub(c.anchorVirtual)) / c.speed))
+	wait := time.Until(due)
+	if wait < -100*time.Millisecond {
+		c.anchorReal, c.anchorVirtual = time.Now(), c.now
+		return 0
+	}
+	return wait
+}
+
+// Fires timers, one at a time, whenever every participant is idle (and the pacing allows).
+func (c *VirtualClock) mainLoop() {
+	defer close(c.done)
+	for {
+		c.mu.Lock()
+		for !(c.ready() && c.busy == 0) && !c.stopped {
+			c.cond.Wait()
+		}
+		if c.stopped {
+			c.mu.Unlock()
+			return
+		}
+		if wait := c.realWait(c.timers[0].deadline); wait > 0 {
+			c.mu.Unlock()
+			select {
+			case <-time.After(wait):
+			case <-c.wake:
+			}
+			continue
+		}
+		t := heap.Pop(&c.timers).(*virtualTimer)
+		if t.deadline.After(c.now) {
+			c.now = t.deadline
+		}
+		if p := c.awaited[t.ch]; p != nil {
+			p.add(1) // It wakes up.
+		}
+		t.ch <- c.now
+		c.mu.Unlock()
+	}
+}
+
+// A Participant is a goroutine of a simulation, which its VirtualClock waits for. The clock keeps count of the
+// participants running, plus the requests on their way to them, and moves on only when the count is zero. So a
+// participant must tell the clock when it goes idle, and whoever sends it a request must tell the clock first:
+//
+//   - Join registers a participant, which counts as running. Call it before starting the goroutine.
+//   - Before it blocks in its select, the participant calls Idle, with the timers in the select. It does not count
+//     while it waits. When one of those timers fires, or a request comes on one of its inbox channels (see Listen),
+//     it counts as running again, until its next Idle.
+//   - A sender calls expect (in this package: see e.g. SendPickup) before sending a request to a participant's inbox.
+//     A participant waiting for the reply to a request of its own is still running, and need not call Idle.
+//   - Leave, once it is done.
+//
+// With any other Clock, a Participant does nothing: Sleep sleeps, and the rest returns at once.
+type Participant struct {
+	clock   Clock
+	virtual *VirtualClock      // Or nil.
+	inbox   []uintptr          // The channels it receives requests on. See chanKey.
+	tokens  int                // Its share of virtual.busy: 1 while it runs, plus 1 per request on its way.
+	timers  []<-chan time.Time // What it waits for, as of its last Idle.
+	left    bool
+}
+
+// The participants, by the channels they receive requests on.
+var inboxes = struct {
+	sync.Mutex
+	m map[uintptr]*Participant
+}{m: make(map[uintptr]*Participant)}
+
+// Registers a new participant of the clock, running, which receives requests on the inbox channels.
+func Join(clock Clock, inbox ...interface{}) *Participant {
+	p := &Participant{clock: clock}
+	p.virtual, _ = clock.(*VirtualClock)
+	if p.virtual == nil {
+		return p
+	}
+	p.virtual.mu.Lock()
+	p.add(1)
+	p.virtual.mu.Unlock()
+	p.Listen(inbox...)
+	return p
+}
+
+// Registers more channels the participant receives requests on (e.g. a new Done channel).
+func (p *Participant) Listen(inbox ...interface{}) {
+	if p.virtual == nil {
+		return
+	}
+	inboxes.Lock()
+	defer inboxes.Unlock()
+	for _, ch := range inbox {
+		if key := chanKey(ch); key != 0 {
+			p.inbox = append(p.inbox, key)
+			inboxes.m[key] = p
+		}
+	}
+}
+
+// The participant is about to block until one of the timers fires, or a request comes (or it is closed).
+// Nil timers are ignored.
+func (p *Participant) Idle(timers ...<-chan time.Time) {
+	c := p.virtual
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range p.timers {
+		delete(c.awaited, t)
+	}
+	p.timers = p.timers[:0]
+	fired := false // Then it does not block at all.
+	for _, t := range timers {
+		if t != nil {
+			fired = fired || len(t) > 0
+			p.timers = append(p.timers, t)
+			c.awaited[t] = p
+		}
+	}
+	if !fired {
+		p.add(-1)
+	}
+}
+
+// Like Clock.Sleep.
+func (p *Participant) Sleep(d time.Duration) {
+	if p.virtual == nil {
+		p.clock.Sleep(d)
+		return
+	}
+	t := p.virtual.After(d)
+	p.Idle(t)
+	<-t
+}
+
+// The participant is done: the clock no longer waits for it, nor for requests to its inbox.
+func (p *Participant) Leave() {
+	c := p.virtual
+	if c == nil {
+		return
+	}
+	inboxes.Lock()
+	for _, key := range p.inbox {
+		delete(inboxes.m, key)
+	}
+	inboxes.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range p.timers {
+		delete(c.awaited, t)
+	}
+	p.add(-p.tokens)
+	p.left = true
+}
+
+// Counts n more reasons for the participant to run (n < 0: fewer). Call with virtual.mu held.
+func (p *Participant) add(n int) {
+	if p.left {
+		return
+	}
+	if p.tokens+n < 0 { // A request came from outside the simulation, uncounted.
+		n = -p.tokens
+	}
+	p.tokens += n
+	p.virtual.busy += n
+	if p.virtual.busy == 0 {
+		p.virtual.cond.Signal()
+	}
+}
+
+// The participant will offer a request on ch, in its select (see elevatorDriver.pending): the send wakes both the
+// receiver and the participant.
+func (p *Participant) offer(ch interface{}) {
+	if p.virtual == nil {
+		return
+	}
+	expect(ch)
+	p.virtual.mu.Lock()
+	p.add(1)
+	p.virtual.mu.Unlock()
+}
+
+// Tells the clock of the participant which receives requests on ch (if any) that one is on its way: the clock waits
+// until the participant has handled it. Returns false if no participant receives on ch. Call it before sending, and
+// unexpect if the send is given up.
+func expect(ch interface{}) bool { return addRequest(ch, 1) }
+
+func unexpect(ch interface{}) { addRequest(ch, -1) }
+
+func addRequest(ch interface{}, n int) bool {
+	inboxes.Lock()
+	p := inboxes.m[chanKey(ch)]
+	inboxes.Unlock()
+	if p == nil {
+		return false
+	}
+	p.virtual.mu.Lock()
+	defer p.virtual.mu.Unlock()
+	p.add(n)
+	return true
+}
+
+// Identifies a channel, whichever way it is typed (chan T, <-chan T, chan<- T). Zero for nil.
+func chanKey(ch interface{}) uintptr {
+	v := reflect.ValueOf(ch)
+	if v.Kind() != reflect.Chan || v.IsNil() {
+		return 0
+	}
+	return v.Pointer()
+}
+
+type virtualTimer struct {
+	deadline time.Time
+	seq      int // Creation order, to break ties.
+	ch       chan time.Time
+}
+
+// Min-heap of timers, by deadline then seq. See container/heap.
+type timerHeap []*virtualTimer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].deadline.Equal(h[j].deadline) {
+		return h[i].deadline.Before(h[j].deadline)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h timerHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x interface{}) { *h = append(*h, x.(*virtualTimer)) }
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,118 @@
+package lift
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The VirtualClock jumps to a sleeper's deadline once every participant is idle. Goroutines which do not take part
+// do not hold it up.
+func TestVirtualClockSleep(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	ch := make(chan int)
+	go func() { <-ch }()
+	defer close(ch)
+	start := time.Now()
+	clock.Sleep(time.Hour)
+	if got, want := clock.Now(), Epoch.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("after Sleep, the VirtualClock is at %v, want %v", got, want)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Sleep of a virtual hour took %v", elapsed)
+	}
+}
+
+// Time stands still while a participant handles a request, however long it takes in real time, and whatever
+// it waits for meanwhile (outside the simulation).
+func TestParticipantHoldsTime(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	requests := make(chan int)
+	handled := make(chan time.Time, 2)
+	p := Join(clock, requests)
+	go func() {
+		defer p.Leave()
+		for i := 0; i < 2; i++ {
+			p.Idle()
+			<-requests
+			time.Sleep(20 * time.Millisecond)
+			handled <- clock.Now()
+		}
+	}()
+
+	wake := clock.After(time.Second)
+	for i := 0; i < 2; i++ {
+		expect(requests)
+		requests <- i
+	}
+	<-wake
+	for i := 0; i < 2; i++ {
+		if at := <-handled; !at.Equal(Epoch) {
+			t.Errorf("request %d was handled at %v, want %v: the clock moved on meanwhile", i, at, Epoch)
+		}
+	}
+}
+
+// A participant's timers wake it in deadline order, and it sees the time of each.
+func TestParticipantSleep(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	var mu sync.Mutex
+	var woke []time.Duration
+	var wg sync.WaitGroup
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		d := d
+		p := Join(clock)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer p.Leave()
+			p.Sleep(d)
+			mu.Lock()
+			woke = append(woke, clock.Now().Sub(Epoch))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(woke) != 3 || woke[0] != time.Second || woke[1] != 2*time.Second || woke[2] != 3*time.Second {
+		t.Errorf("the sleepers woke at %v, want [1s 2s 3s]", woke)
+	}
+}
+
+// Two VirtualClocks in one process run independently, and a goroutine blocked in a system call holds up neither.
+func TestVirtualClocksIndependent(t *testing.T) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	defer syscall.Close(fds[0])
+	go func() {
+		buf := make([]byte, 1)
+		syscall.Read(fds[0], buf) // Blocks until the pipe is closed.
+	}()
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			clock := NewVirtualClock(Epoch)
+			defer clock.Stop()
+			p := Join(clock)
+			defer p.Leave()
+			for k := 0; k < 100; k++ {
+				p.Sleep(time.Minute)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the VirtualClocks are stuck")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package lift
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// A Dispatcher chooses which Conveyor serves a Pickup (hall call).
+// The System queries every Conveyor for a PickupEstimate, and passes all of them (in id order) to Dispatch.
+// Dispatch is only called from the System's goroutine, so implementations need not be thread-safe,
+// but each System needs its own Dispatcher.
+type Dispatcher interface {
+	Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor
+}
+
+// Names accepted by NewDispatcher.
+var DispatcherNames = []string{"random", "round-robin", "nearest", "least-loaded", "eta"}
+
+// Returns a new built-in Dispatcher by name. The seed is used by the random dispatcher only.
+func NewDispatcher(name string, seed int64) (Dispatcher, error) {
+	switch name {
+	case "random":
+		return NewRandomDispatcher(seed), nil
+	case "round-robin":
+		return &RoundRobinDispatcher{}, nil
+	case "nearest":
+		return NearestCarDispatcher{}, nil
+	case "least-loaded":
+		return LeastLoadedDispatcher{}, nil
+	case "eta", "":
+		return ETADispatcher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown dispatcher %q (want one of %v)", name, DispatcherNames)
+	}
+}
+
+// RandomDispatcher ignores the estimates and picks any Conveyor.
+type RandomDispatcher struct {
+	rand *rand.Rand
+}
+
+func NewRandomDispatcher(seed int64) *RandomDispatcher {
+	return &RandomDispatcher{rand.New(rand.NewSource(seed))}
+}
+func (d *RandomDispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	return estimates[d.rand.Intn(len(estimates))].Conveyor
+}
+
+// RoundRobinDispatcher ignores the estimates and assigns each Conveyor in turn.
+type RoundRobinDispatcher struct {
+	next int
+}
+
+func (d *RoundRobinDispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	i := d.next % len(estimates)
+	d.next = i + 1
+	return estimates[i].Conveyor
+}
+
+// NearestCarDispatcher picks the Conveyor which is fewest floors from the pickup now,
+// regardless of where it is going.
+type NearestCarDispatcher struct{}
+
+func (NearestCarDispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	return bestEstimate(estimates, func(a, b PickupEstimate) bool {
+		return a.Floor.distance(pickup.Floor) < b.Floor.distance(pickup.Floor)
+	}).Conveyor
+}
+
+// LeastLoadedDispatcher picks the Conveyor with the fewest passengers: those aboard, and those waiting at the hall
+// calls dispatched to it. Ties go to the nearest.
+type LeastLoadedDispatcher struct{}
+
+func (LeastLoadedDispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	return bestEstimate(estimates, func(a, b PickupEstimate) bool {
+		if loadA, loadB := a.Load+a.Assigned, b.Load+b.Assigned; loadA != loadB {
+			return loadA < loadB
+		}
+		return a.Floor.distance(pickup.Floor) < b.Floor.distance(pickup.Floor)
+	}).Conveyor
+}
+
+// ETADispatcher picks the Conveyor which would arrive at the pickup soonest (PickupEstimate.Cost).
+type ETADispatcher struct{}
+
+func (ETADispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	return bestEstimate(estimates, PickupEstimate.betterThan).Conveyor
+}
+
+// Returns the first estimate which no later estimate is better than.
+func bestEstimate(estimates []PickupEstimate, better func(a, b PickupEstimate) bool) PickupEstimate {
+	best := estimates[0]
+	for _, est := range estimates[1:] {
+		if better(est, best) {
+			best = est
+		}
+	}
+	return best
+}
@@ -0,0 +1,47 @@
+package lift
+
+import "testing"
+
+// LeastLoadedDispatcher counts passengers, not stops: a car sent one group of four is busier than a car sent a
+// single passenger, though each has one stop to make.
+func TestLeastLoadedCountsPersons(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	clock.Pause() // The cars stay at 0, with their calls.
+	s := NewSystem(8, DefaultCarSpecs(2), LeastLoadedDispatcher{}, clock, nil, nil)
+	defer s.Close()
+
+	for _, c := range []struct {
+		pickup Pickup
+		want   int
+	}{
+		{Pickup{Floor: 5, Dir: UP, Persons: 4}, 0}, // Both are empty: the first of the nearest.
+		{Pickup{Floor: 2, Dir: UP}, 1},             // Elevator-0 has four coming.
+		{Pickup{Floor: 3, Dir: UP}, 1},             // Still: one against four.
+		{Pickup{Floor: 6, Dir: DOWN, Persons: 2}, 1},
+		{Pickup{Floor: 4, Dir: DOWN}, 0}, // Four each: the first of the nearest.
+	} {
+		if err := SendPickup(s, c.pickup); err != nil {
+			t.Fatal(err)
+		}
+		if got := dispatchedTo(s, c.pickup.FloorDir()); got != c.want {
+			t.Errorf("%s %s went to Elevator-%d, want Elevator-%d", c.pickup.Floor, c.pickup.Dir, got, c.want)
+		}
+	}
+}
+
+// A car's passengers aboard count as much as those it is sent for.
+func TestLeastLoadedCountsAboard(t *testing.T) {
+	cars := []*Elevator{{id: 0}, {id: 1}}
+	estimates := []PickupEstimate{
+		{Conveyor: cars[0], Floor: 2, Load: 3},
+		{Conveyor: cars[1], Floor: 0, Assigned: 2, Pending: 2},
+	}
+	if got := (LeastLoadedDispatcher{}).Dispatch(Pickup{Floor: 2, Dir: UP}, estimates); got != cars[1] {
+		t.Errorf("got Elevator-%d, want Elevator-1", got.Id())
+	}
+	estimates[1].Assigned = 3
+	if got := (LeastLoadedDispatcher{}).Dispatch(Pickup{Floor: 2, Dir: UP}, estimates); got != cars[0] {
+		t.Errorf("got Elevator-%d, want Elevator-0: as loaded, and nearer", got.Id())
+	}
+}
@@ -0,0 +1,167 @@
+package lift
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// The doors of an Elevator cycle CLOSED -> OPENING -> OPEN -> CLOSING -> CLOSED.
+// The car may only move while the doors are CLOSED.
+type DoorState int
+
+const (
+	DoorsClosed DoorState = iota
+	DoorsOpening
+	DoorsOpen
+	DoorsClosing
+)
+
+func (ds DoorState) String() string {
+	switch ds {
+	case DoorsClosed:
+		return "CLOSED"
+	case DoorsOpening:
+		return "OPENING"
+	case DoorsOpen:
+		return "OPEN"
+	case DoorsClosing:
+		return "CLOSING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// A DoorState is written (e.g. in a Snapshot) as its String.
+func (ds DoorState) MarshalText() ([]byte, error) { return []byte(ds.String()), nil }
+func (ds *DoorState) UnmarshalText(b []byte) error {
+	for _, state := range []DoorState{DoorsClosed, DoorsOpening, DoorsOpen, DoorsClosing} {
+		if string(b) == state.String() {
+			*ds = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown door state %q", b)
+}
+
+// How long each part of the door cycle takes.
+type DoorTimes struct {
+	Opening   time.Duration // From CLOSED to OPEN.
+	Dwell     time.Duration // How long the doors stay OPEN.
+	Closing   time.Duration // From OPEN to CLOSED.
+	Extension time.Duration // When a passenger boards (sends a Dropoff), the doors stay OPEN at least this much longer.
+}
+
+// A full door cycle takes TimeServiceFloor, which leaves passengers TimeSelectDropoff to board.
+var DefaultDoorTimes = DoorTimes{Opening: 4 * Tick, Dwell: TimeServiceFloor - 8*Tick, Closing: 4 * Tick, Extension: 5 * Tick}
+
+// Returns true if the car is stopped at e.floor for a door cycle.
+func (e *Elevator) doorsBusy() bool { return e.door != DoorsClosed }
+
+func (e *Elevator) setDoors(state DoorState, d time.Duration) {
+	log.Printf("Elevator-%d doors %s at %s\n", e.id, state, e.floor)
+	if state != e.door {
+		e.emitDoors(state)
+	}
+	e.door = state
+	e.doorDeadline = e.clock.Now().Add(d)
+	e.doorTimer = e.clock.After(d)
+}
+
+var doorEvents = map[DoorState]EventKind{DoorsOpening: EventDoorsOpening, DoorsOpen: EventDoorsOpen,
+	DoorsClosing: EventDoorsClosing, DoorsClosed: EventDoorsClosed}
+
+func (e *Elevator) emitDoors(state DoorState) {
+	e.events.emit(Event{Kind: doorEvents[state], Car: e.id, Floor: e.floor, Load: e.load.persons})
+}
+
+// Starts the door cycle (or reopens closing doors). We serve the floor when the doors are OPEN.
+func (e *Elevator) openDoors() {
+	switch e.door {
+	case DoorsClosed, DoorsClosing:
+		e.setDoors(DoorsOpening, e.doorTimes.Opening)
+	}
+}
+
+// Keeps OPEN doors open for at least the Extension, e.g. while passengers board.
+func (e *Elevator) extendDwell() {
+	if e.door == DoorsOpen && e.doorDeadline.Sub(e.clock.Now()) < e.doorTimes.Extension {
+		e.setDoors(DoorsOpen, e.doorTimes.Extension)
+	}
+}
+
+func (e *Elevator) onDoorTimer() {
+	switch e.door {
+	case DoorsOpening:
+		dwell := e.doorTimes.Dwell
+		if e.faults[FaultDoorJam] {
+			dwell = DoorJamRetry
+		}
+		e.setDoors(DoorsOpen, dwell)
+		e.serveFloor()
+	case DoorsOpen:
+		e.setDoors(DoorsClosing, e.doorTimes.Closing)
+	case DoorsClosing:
+		if e.jammed {
+			e.onDoorsJammed()
+			return
+		}
+		e.closeTries = 0
+		e.clearFault(FaultDoorJam)
+		log.Printf("Elevator-%d doors %s at %s\n", e.id, DoorsClosed, e.floor)
+		e.emitDoors(DoorsClosed)
+		e.door = DoorsClosed
+		e.doorTimer = nil
+		e.departFloor()
+	}
+}
+
+// The doors are open: passengers for this floor get out. Clear the requests for this floor (in our direction),
+// and notify the waiters. If we are still full (or out of the group), we only let passengers out.
+func (e *Elevator) serveFloor() {
+	e.dropoffs.clear(e.floor)
+	alighted := e.alight()
+	arrival := e.arrival(e.dir)
+	arrival.Alighted = alighted
+	if e.full() || e.outOfGroup() {
+		arrival.Dir = IDLE // Dropoffs only.
+		e.arrive(arrival)
+		return
+	}
+	if e.dir != IDLE {
+		if e.pickups(e.dir).clear(e.floor) { // FUTURE: signal correct pickup light to clear.
+			arrival.Aged = e.overdue(FloorDir{e.floor, e.dir})
+		}
+		e.arrive(arrival)
+		return
+	}
+	// We have no direction, so passengers going either way may board.
+	e.arrive(arrival) // Dropoffs
+	for _, dir := range []Direction{UP, DOWN} {
+		if e.pickups(dir).clear(e.floor) {
+			arrival := e.arrival(dir)
+			arrival.Aged = e.overdue(FloorDir{e.floor, dir})
+			e.arrive(arrival)
+		}
+	}
+}
+
+// The doors have closed: choose our next stop.
+func (e *Elevator) departFloor() {
+	dest, ok := e.calculateNextStop()
+	if !ok {
+		e.dest = e.floor
+		e.dir = IDLE
+		return
+	}
+	if dest == e.floor {
+		// Very special case (ick): nothing ahead, but the current floor has a pickup in the opposite direction.
+		// Turn around, and reopen the doors for those passengers.
+		e.dir = e.dir.opposite()
+		e.openDoors()
+		return
+	}
+	e.dir = IDLE // We are at rest: gotoFloor determines dir from floor and dest.
+	e.dest = e.floor
+	e.gotoFloor(dest) // sets e.dest, e.dir
+}
@@ -0,0 +1,125 @@
+package lift
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Door times for the tests, distinct enough to tell each part of the cycle apart.
+var testDoorTimes = DoorTimes{Opening: time.Second, Dwell: 3 * time.Second, Closing: 2 * time.Second, Extension: time.Second}
+
+// Returns DefaultCarSpec, with the door times.
+func doorSpec(doors DoorTimes) CarSpec {
+	spec := DefaultCarSpec
+	spec.Doors = doors
+	return spec
+}
+
+// Returns a car of numFloors, idle at 0, on a VirtualClock; the recorder of its Events; the test's Participant of
+// the clock; and a func to close them all.
+func testCar(numFloors int, spec CarSpec) (*Elevator, *eventRecorder, *Participant, func()) {
+	clock := NewVirtualClock(Epoch)
+	events := &eventRecorder{}
+	e := newElevator(0, numFloors, spec, clock, newEventLog(events, clock), nil)
+	p := Join(clock)
+	return e, events, p, func() {
+		p.Leave()
+		e.Close()
+		clock.Stop()
+	}
+}
+
+// Returns the car's door Events and departures, up to its first departure, as "kind@time" (from Epoch).
+func doorCycle(events *eventRecorder) string {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	var cycle []string
+	for _, ev := range events.events {
+		switch ev.Kind {
+		case EventDoorsOpening, EventDoorsOpen, EventDoorsClosing, EventDoorsClosed, EventDeparture:
+			cycle = append(cycle, fmt.Sprintf("%s@%v", ev.Kind, ev.Time.Sub(Epoch)))
+		}
+		if ev.Kind == EventDeparture {
+			break
+		}
+	}
+	return strings.Join(cycle, " ")
+}
+
+// The doors open, dwell and close in their times, and the car chooses where to go next only once they are closed.
+func TestDoorCycle(t *testing.T) {
+	e, events, p, close := testCar(6, doorSpec(testDoorTimes))
+	defer close()
+
+	done := doneChan(p)
+	if err := SendPickup(e, Pickup{Floor: 0, Dir: UP, Done: done}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, done, 0, "0 UP")
+	if err := SendDropoff(e, Dropoff{Floor: 3, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+
+	want := "doors-opening@0s doors-open@1s doors-closing@4s doors-closed@6s departure@6s"
+	if got := doorCycle(events); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// A passenger who boards late keeps the doors open for the Extension.
+func TestDoorDwellExtension(t *testing.T) {
+	doors := testDoorTimes
+	doors.Extension = 5 * time.Second
+	e, events, p, close := testCar(6, doorSpec(doors))
+	defer close()
+
+	done := doneChan(p)
+	if err := SendPickup(e, Pickup{Floor: 0, Dir: UP, Done: done}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, done, 0, "0 UP")
+	p.Sleep(2500 * time.Millisecond) // Half a second before the doors would close.
+	if err := SendDropoff(e, Dropoff{Floor: 3, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+
+	want := "doors-opening@0s doors-open@1s doors-closing@8.5s doors-closed@10.5s departure@10.5s"
+	if got := doorCycle(events); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// A hall call at the car's floor, its way, reopens closing doors, and is served with them.
+func TestDoorsReopen(t *testing.T) {
+	e, events, p, close := testCar(6, doorSpec(testDoorTimes))
+	defer close()
+
+	first := doneChan(p)
+	if err := SendPickup(e, Pickup{Floor: 0, Dir: UP, Done: first}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, first, 0, "0 UP")
+	p.Sleep(4 * time.Second) // The doors are closing.
+	second := doneChan(p)
+	if err := SendPickup(e, Pickup{Floor: 0, Dir: UP, Done: second}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, second, 0, "0 UP again")
+	if at := e.clock.Now().Sub(Epoch); at != 6*time.Second {
+		t.Errorf("the second passenger boarded at %v, want 6s", at)
+	}
+	if err := SendDropoff(e, Dropoff{Floor: 3, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+
+	want := "doors-opening@0s doors-open@1s doors-closing@4s doors-opening@5s doors-open@6s doors-closing@9s " +
+		"doors-closed@11s departure@11s"
+	if got := doorCycle(events); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
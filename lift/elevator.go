@@ -3,6 +3,7 @@ package lift
 import (
 	"fmt"
 	"log"
+	"time"
 )
 
 /*
@@ -47,57 +48,218 @@ import (
 		  		else:
 		  			direction = 0
 
+	Direction commitment (CollectiveSelective; the scenarios in scenarios_test.go check these rules)
+	1. A moving car keeps its direction while any request (dropoff, or pickup either way) lies ahead,
+		except as in rule 4.
+	2. On the way, it stops for dropoffs, and for pickups in its direction. Pickups the other way wait.
+	3. When nothing lies ahead but pickups the other way, it goes to the furthest of them, and turns there
+		on arrival: those passengers board with the first door cycle (see turnOnArrival).
+	4. So an IDLE elevator at floor 1, given a pickup DOWN from floor 10, heads UP towards 10.
+		If it gets an UP request from floor 15 en route, it passes 10 without stopping, carries on to 15 (then on up,
+		for its passengers), and takes 10 DOWN on the way back (see shouldRetarget). But if it is already braking
+		for 10, it stops there, and since it has nothing else to do at 10 and nobody aboard is going up, it turns
+		DOWN there; 15 waits for the next sweep up (see turnOnArrival).
+	5. If a passenger who boarded going DOWN requests a dropoff above, the car accepts it (by rule 1), and
+		serves it after it has served everything below. It never refuses a dropoff for being the wrong way.
+	6. An IDLE car (its doors closed) serves a pickup at its floor at once, and takes the pickup's direction
+		(so rule 5 applies to its passengers). Otherwise it heads for its nearest request.
+
 	FUTURE - Some cases not handled yet.
-	- An IDLE elevator at floor 1 receives a pickup DOWN from floor 10. It heads toward 10.
-		If it gets an UP request from floor 15, en route, it will not service it before taking 10 DOWN.
-	 	If pickup DOWN @ 10 is serviced, and passenger requests dropoff above, what should we do?
-	- An IDLE elevator receives a pickup request for the floor it is on. Yep, not handled.
-	- A pickup is issued to an elevator A. Later, elevator B stops at that floor, going in the right direction.
-		Then the first pickup should be cancelled.
-	- How to prevent starvation? Should pickup requests be handled in the order received?
+	- Starvation: a pickup which waits longer than MaxWait is made before any other request (see agedPickup),
+		even if the car must turn around for it. Otherwise, pickups are not handled in the order received.
 */
 
 // Elevator implements Conveyor:
-// 	- Reads Pickups from a channel.
-//	- Reads Dropoffs from a channel.
-//	- Writes Arrivals to a channel.
+//   - Reads Pickups from a channel.
+//   - Reads Dropoffs from a channel.
+//   - Writes Arrivals to a channel.
+//   - Reads PickupCancellations from a channel.
+//
 // Internally, it stores
+//
 //		dropoff [floorNum] (request issued inside elevator by passenger)
 //		pickup [floorNum] (request issued outside elevator by potential passenger)
 //	It is not possible to cancel a dropoff request (as in real elevators).
 type Elevator struct {
-	id          int
-	numFloors   int
-	floor       Floor        // The last floor we passed, or (if dir==IDLE, the floor we are sitting on). The floor has already been serviced.
-	dest        Floor        // The current destination. dir == floor.DirectionTo(dest). If dir == IDLE, dest == floor.
-	dir         Direction    // Current direction of the elevator: UP, DOWN, or IDLE.
-	dropoffs    *FloorSet    // Which dropoffs (destinations) are requested
-	pickupsUp   *FloorSet    // Which pickups (origins) are requested UP
-	pickupsDown *FloorSet    // Which pickups (origins) are requested DOWN
-	chPickups   chan Pickup  // System sends us pickup demands
-	chDropoffs  chan Dropoff // System (or Passenger) sends us dropoff requests from inside elevator.
-	chArrivals  chan Arrival // We send when we arrive at a floor (in a direction). FUTURE: Should send dir=IDLE if no outstanding reqs.
-	waiters     ArrivalListeners
-	drive       *elevatorDriver
-	//	chPickupQueries chan Pickup // System asks for pickup estimates
-	//	chPickupQueryEstimates chan PickupEstimate // System asks for pickup estimates
-}
-
-func NewElevator(id int, numFloors int) *Elevator {
-	e := &Elevator{id, numFloors, 0, 0, IDLE,
-		newFloorSet(numFloors), newFloorSet(numFloors), newFloorSet(numFloors),
-		make(chan Pickup), make(chan Dropoff), make(chan Arrival),
-		make(ArrivalListeners), newDriver(id)}
-	go e.mainLoop()
+	id           int
+	numFloors    int
+	floor        Floor            // The last floor we passed, or (if dir==IDLE, the floor we are sitting on). The floor has already been serviced.
+	dest         Floor            // The current destination. dir == floor.DirectionTo(dest). If dir == IDLE, dest == floor.
+	dir          Direction        // Current direction of the elevator: UP, DOWN, or IDLE.
+	dropoffs     *FloorSet        // Which dropoffs (destinations) are requested
+	pickupsUp    *FloorSet        // Which pickups (origins) are requested UP
+	pickupsDown  *FloorSet        // Which pickups (origins) are requested DOWN
+	chPickups    chan Pickup      // System sends us pickup demands
+	chDropoffs   chan Dropoff     // System (or Passenger) sends us dropoff requests from inside elevator.
+	chArrivals   chan Arrival     // We send when we arrive at a floor (in a direction). FUTURE: Should send dir=IDLE if no outstanding reqs.
+	chQueries    chan PickupQuery // System asks for pickup estimates
+	chCancels    chan Pickup      // System cancels pickups which another elevator has made.
+	chReturns    chan Pickup      // We hand back pickups we will not make (e.g., we are full).
+	chStatus     chan StatusQuery // Anyone may ask for our CarStatus.
+	chWatches    chan StatusWatch
+	chSnapshots  chan SnapshotQuery
+	watchers     map[chan<- CarStatus]bool // Receive our CarStatus when it changes.
+	lastStatus   CarStatus                 // As last sent to the watchers.
+	waiters      ArrivalListeners
+	drive        *elevatorDriver
+	clock        Clock
+	door         DoorState // The car only moves while DoorsClosed. Otherwise, it is stopped at floor.
+	doorTimes    DoorTimes
+	doorTimer    <-chan time.Time // Fires at the end of the current door state. nil while DoorsClosed.
+	doorDeadline time.Time        // When doorTimer fires.
+	capacity     Capacity
+	bypassLoad   float64       // Fraction of capacity at which we stop answering hall calls.
+	load         load          // Passengers aboard.
+	alighting    []load        // Passengers aboard, by dropoff floor.
+	served       *FloorSet     // The floors we may stop at.
+	policy       StopPolicy    // Chooses our next stop.
+	floorTime    time.Duration // How long we take to travel one (average) floor at rated speed. For estimates.
+	stopTime     time.Duration // How much longer we take to stop at a floor than to pass it, besides the doors.
+	maxWait      time.Duration
+	pickupSince  map[FloorDir]time.Time // When each pickup was called. Zero for an Aged pickup. May hold stale entries.
+	pickupCalls  map[FloorDir]int64     // The System's id of each pickup, for Events. May hold stale entries.
+	events       *eventLog
+	journal      *Journal
+	life         lifecycle
+	part         *Participant // Our mainLoop, as a participant of the clock.
+
+	// Faults: see fault.go.
+	faults        map[FaultKind]bool // Detected, and not yet cleared. While we have one (but FaultSlow), we take no hall calls.
+	chInject      chan Fault         // Faults injected into our hardware.
+	chFaults      chan FaultReport   // We report the faults we detect, and their clearing.
+	pendingFaults []FaultReport      // Not yet received by the System. As the driver's notifications, we never block sending them.
+	jammed        bool               // Injected: the doors will not close.
+	closeTries    int                // How many times the doors have failed to close, since they last closed.
+	watchdog      <-chan time.Time   // Fires if the drive does not reach the next floor in time. nil at rest.
+	lastFloorAt   time.Time          // When the drive last reached a floor, or set off.
+	runOrigin     Floor              // Where the drive's current run set off.
+
+	// Service modes: see mode.go.
+	mode    CarMode
+	chModes chan ModeRequest
+	chMoves chan MoveRequest
+}
+
+// Describes one Elevator car.
+// See also Building, which describes cars as data.
+type CarSpec struct {
+	Motion       MotionProfile
+	Levels       []float64 // The height of each floor (from the bottom floor) in metres. nil means DefaultFloorHeight apart.
+	Doors        DoorTimes
+	Capacity     Capacity
+	BypassLoad   float64    // Fraction of Capacity at which the car stops answering hall calls (full-car bypass). Zero means DefaultBypassLoad.
+	ServedFloors []Floor    // The floors at which the car may stop. nil means all.
+	StopPolicy   StopPolicy // nil means CollectiveSelective.
+	// A pickup which has waited longer than this is made before any other request (the aging rule, which
+	// prevents starvation). The System also escalates it to the car which can make it soonest. Zero means no limit.
+	MaxWait time.Duration
+}
+
+var DefaultCarSpec = CarSpec{Motion: DefaultMotion, Doors: DefaultDoorTimes, Capacity: DefaultCapacity,
+	BypassLoad: DefaultBypassLoad}
+
+// Returns n copies of DefaultCarSpec.
+func DefaultCarSpecs(n int) []CarSpec {
+	specs := make([]CarSpec, n)
+	for i := range specs {
+		specs[i] = DefaultCarSpec
+	}
+	return specs
+}
+
+func NewElevator(id int, numFloors int, spec CarSpec, clock Clock) *Elevator {
+	return newElevator(id, numFloors, spec, clock, nil, nil)
+}
+
+func newElevator(id int, numFloors int, spec CarSpec, clock Clock, events *eventLog, journal *Journal) *Elevator {
+	e := makeElevator(id, numFloors, spec, clock, events, journal)
+	e.start()
+	return e
+}
+
+// Returns an Elevator at rest at the bottom floor, with no requests. It does not run until start.
+func makeElevator(id int, numFloors int, spec CarSpec, clock Clock, events *eventLog, journal *Journal) *Elevator {
+	if spec.Motion.Speed <= 0 {
+		spec.Motion = DefaultMotion
+	}
+	if spec.StopPolicy == nil {
+		spec.StopPolicy = CollectiveSelective{}
+	}
+	if spec.BypassLoad <= 0 {
+		spec.BypassLoad = DefaultBypassLoad
+	}
+	levels := spec.Levels
+	if levels == nil {
+		levels = defaultLevels(numFloors)
+	}
+	floorTime := TimeBetweenFloors
+	if numFloors > 1 {
+		floorTime = spec.Motion.cruiseTime((levels[numFloors-1] - levels[0]) / float64(numFloors-1))
+	}
+	e := &Elevator{id: id, numFloors: numFloors, floor: 0, dest: 0, dir: IDLE,
+		dropoffs: newFloorSet(numFloors), pickupsUp: newFloorSet(numFloors), pickupsDown: newFloorSet(numFloors),
+		chPickups: make(chan Pickup), chDropoffs: make(chan Dropoff), chArrivals: make(chan Arrival),
+		chQueries: make(chan PickupQuery), chCancels: make(chan Pickup), chReturns: make(chan Pickup), chStatus: make(chan StatusQuery),
+		chWatches: make(chan StatusWatch), chSnapshots: make(chan SnapshotQuery), watchers: make(map[chan<- CarStatus]bool),
+		waiters: make(ArrivalListeners), drive: newDriver(id, spec.Motion, levels, clock), clock: clock,
+		door: DoorsClosed, doorTimes: spec.Doors,
+		capacity: spec.Capacity, bypassLoad: spec.BypassLoad, alighting: make([]load, numFloors),
+		served: newFloorSet(numFloors), floorTime: floorTime, stopTime: spec.Motion.stopTime(), policy: spec.StopPolicy,
+		maxWait: spec.MaxWait, pickupSince: make(map[FloorDir]time.Time), pickupCalls: make(map[FloorDir]int64),
+		events: events, journal: journal, life: lifecycle{quit: make(chan struct{}), clock: clock},
+		faults: make(map[FaultKind]bool), chInject: make(chan Fault), chFaults: make(chan FaultReport),
+		mode: ModeNormal, chModes: make(chan ModeRequest), chMoves: make(chan MoveRequest)}
+	for f := Floor(0); int(f) < numFloors; f++ {
+		e.served.set(f)
+	}
+	if spec.ServedFloors != nil {
+		e.served = newFloorSet(numFloors)
+		for _, f := range spec.ServedFloors {
+			e.served.set(f)
+		}
+	}
 	return e
 }
 
-//func (e *Elevator) PickupQueries() chan<- Pickup { return e.chPickupQueries }
-//func (e *Elevator) PickupQueryEstimates() chan<- PickupEstimate { return e.chPickupQueryEstimates }
-func (e *Elevator) Id() int                  { return e.id }
-func (e *Elevator) Pickups() chan<- Pickup   { return e.chPickups }
-func (e *Elevator) Dropoffs() chan<- Dropoff { return e.chDropoffs }
-func (e *Elevator) Arrivals() <-chan Arrival { return e.chArrivals }
+// Starts us, then our drive: it may have notifications for us already (if restored).
+func (e *Elevator) start() {
+	e.life.goroutine(e.mainLoop, e.chQueries, e.chPickups, e.chDropoffs, e.chCancels, e.drive.chNotifications,
+		e.chInject, e.chModes, e.chMoves, e.chStatus, e.chSnapshots, e.chWatches)
+	e.drive.start()
+}
+
+func (e *Elevator) Id() int                               { return e.id }
+func (e *Elevator) Pickups() chan<- Pickup                { return e.chPickups }
+func (e *Elevator) Dropoffs() chan<- Dropoff              { return e.chDropoffs }
+func (e *Elevator) Arrivals() <-chan Arrival              { return e.chArrivals }
+func (e *Elevator) Closed() <-chan struct{}               { return e.life.quit }
+func (e *Elevator) PickupQueries() chan<- PickupQuery     { return e.chQueries }
+func (e *Elevator) PickupCancellations() chan<- Pickup    { return e.chCancels }
+func (e *Elevator) PickupReturns() <-chan Pickup          { return e.chReturns }
+func (e *Elevator) StatusQueries() chan<- StatusQuery     { return e.chStatus }
+func (e *Elevator) StatusWatches() chan<- StatusWatch     { return e.chWatches }
+func (e *Elevator) SnapshotQueries() chan<- SnapshotQuery { return e.chSnapshots }
+func (e *Elevator) FaultInjections() chan<- Fault         { return e.chInject }
+func (e *Elevator) Faults() <-chan FaultReport            { return e.chFaults }
+func (e *Elevator) ModeRequests() chan<- ModeRequest      { return e.chModes }
+func (e *Elevator) MoveRequests() chan<- MoveRequest      { return e.chMoves }
+func (e *Elevator) Close()                                { e.life.close() }
+
+// Returns true if we stop at the pickup floor, and at one of its Dests (if any), and its Persons (if known) fit.
+func (e *Elevator) serves(pickup Pickup) bool {
+	if !e.served.isSet(pickup.Floor) || (pickup.Persons > 0 && !e.fits(groupLoad(pickup.Persons))) {
+		return false
+	}
+	if len(pickup.Dests) == 0 {
+		return true
+	}
+	for _, dest := range pickup.Dests {
+		if dest >= 0 && int(dest) < e.numFloors && e.served.isSet(dest) {
+			return true
+		}
+	}
+	return false
+}
 
 // Passenger inside elevator punches a floor button
 func (e *Elevator) pickups(dir Direction) *FloorSet {
@@ -118,8 +280,10 @@ func (e *Elevator) gotoFloor(dest Floor) {
 	}
 
 	// Call drive synchronously (via chan + wait for reply).
+	atRest := e.dir == IDLE
 	chReply := make(chan Floor)
 	log.Printf("Elevator-%d sending drive to %v", e.id, dest)
+	expect(e.drive.chRequests)
 	e.drive.chRequests <- DriverDestRequest{dest, chReply}
 	newDest := <-chReply
 	e.dir = e.floor.DirectionTo(e.dest) // whether the new e.dest is the specified dest, or if it failed, stil udpate dir.
@@ -127,18 +291,32 @@ func (e *Elevator) gotoFloor(dest Floor) {
 		log.Printf("Elevator-%d drive accepted new dest %v", e.id, dest)
 		e.dest = dest
 		e.dir = e.floor.DirectionTo(dest)
+		if atRest {
+			e.events.emit(Event{Kind: EventDeparture, Car: e.id, Floor: e.floor, Dir: eventDir(e.dir), Dest: &dest})
+			e.watchRun()
+		} else {
+			e.armWatchdog() // A new run, from the same start.
+		}
 	} else {
 		log.Printf("Elevator-%d drive rejected new dest %v, sticking with %v", e.id, dest, newDest)
 	}
 }
 
-func (e *Elevator) mainLoop() {
+func (e *Elevator) mainLoop(p *Participant) {
+	e.part = p
 	for {
+		var chFaults chan FaultReport // nil (disabled) unless there is something to send
+		var nextFault FaultReport
+		if len(e.pendingFaults) > 0 {
+			chFaults, nextFault = e.chFaults, e.pendingFaults[0]
+		}
+
+		p.Idle(e.doorTimer, e.watchdog)
 		select {
-		//		case pickupQuer := <-e.chPickupQueries:
-		//			// Passenger outside elevator requests pickup. System requests estimates from several elevators.
-		//			// Return #stops/distance/etc. before this pickup
-		//			e.onPickupQuery(pickupQuery)    // FUTURE: Handle proposals.
+		case query := <-e.chQueries:
+			// Passenger outside elevator requests pickup. System requests estimates from several elevators.
+			// Return #stops/distance/etc. before this pickup
+			query.Reply <- e.estimatePickup(query.Pickup)
 
 		case pickup := <-e.chPickups:
 			// Passenger outside elevator requests pickup. System assigns request to us.
@@ -148,56 +326,214 @@ func (e *Elevator) mainLoop() {
 			// Passenger inside elevator requests dropoff
 			e.onDropoffReq(dropoff)
 
+		case pickup := <-e.chCancels:
+			// Another elevator has made this pickup
+			e.onPickupCancellation(pickup)
+
 		case s := <-e.drive.chNotifications:
 			// ElevatorDrive has passed or stopped at a floor
 			e.onDriveNotification(s)
+
+		case <-e.doorTimer:
+			// Doors finished opening, dwelling or closing
+			e.onDoorTimer()
+
+		case <-e.watchdog:
+			// The drive has not reached the next floor in time
+			e.onWatchdog()
+
+		case f := <-e.chInject:
+			e.onInjectedFault(f)
+
+		case chFaults <- nextFault:
+			e.pendingFaults = e.pendingFaults[1:]
+
+		case req := <-e.chModes:
+			e.onModeRequest(req)
+
+		case req := <-e.chMoves:
+			e.onMoveRequest(req)
+
+		case query := <-e.chStatus:
+			query.Reply <- e.status()
+
+		case query := <-e.chSnapshots:
+			query.Reply <- e.snapshot()
+
+		case watch := <-e.chWatches:
+			if watch.Watch {
+				e.watchers[watch.Ch] = true
+				e.lastStatus = e.status()
+				e.sendStatus(watch.Ch, e.lastStatus)
+			} else {
+				delete(e.watchers, watch.Ch)
+			}
+
+		case <-e.life.quit:
+			e.shutdown()
+			return
 		}
+		e.publishStatus()
 	}
 }
 
-/*
-func (e *Elevator) onPickupQuery(pickup Pickup) {
-	stopsUntilPickup := 0
-	distanceUntilPickup := 0
-	goinThereAnyway := false
-	// TODO: Fill in these values
-	e.chPickupQueryEstimates <- &PickupEstimate{ pickup, stopsUntilPickup, distanceUntilPickup, goinThereAnyway }
+// Sends our CarStatus to the watchers, if it has changed since we last did.
+func (e *Elevator) publishStatus() {
+	if len(e.watchers) == 0 {
+		return
+	}
+	status := e.status()
+	if status.equal(e.lastStatus) {
+		return
+	}
+	e.lastStatus = status
+	for ch := range e.watchers {
+		e.sendStatus(ch, status)
+	}
+}
+
+func (e *Elevator) sendStatus(ch chan<- CarStatus, status CarStatus) {
+	select {
+	case ch <- status:
+	default: // The watcher is behind: it misses this CarStatus, but the next one brings it up to date.
+	}
+}
+
+// We are closing: reject the requests we have not served, and stop the drive. We stay where we are.
+func (e *Elevator) shutdown() {
+	log.Printf("Elevator-%d shutting down at %s, doors %s\n", e.id, e.floor, e.door)
+	for _, f := range e.dropoffs.floors() {
+		e.events.emit(Event{Kind: EventCancellation, Car: e.id, Floor: f})
+	}
+	e.waiters.cancelAll(e, &e.life)
+	e.drive.close()
+	e.doorTimer = nil
+}
+
+func (e *Elevator) status() CarStatus {
+	return CarStatus{Id: e.id, Floor: e.floor, Dest: e.dest, Dir: e.dir, Doors: e.door, Load: e.load.persons,
+		Dropoffs: e.dropoffs.floors(), PickupsUp: e.pickupsUp.floors(), PickupsDown: e.pickupsDown.floors(),
+		Faults: e.faultKinds(), Mode: e.mode}
+}
+
+// Estimates the cost of serving the pickup, without changing our state.
+// We replay our own stop selection on a copy of our requests (plus the pickup) until we would arrive at the pickup.
+func (e *Elevator) estimatePickup(pickup Pickup) PickupEstimate {
+	est := PickupEstimate{Pickup: pickup, Conveyor: e, Floor: e.floor,
+		Pending: e.dropoffs.count() + e.pickupsUp.count() + e.pickupsDown.count(), Load: e.load.persons,
+		Bypass: e.full() || e.outOfGroup() || !e.serves(pickup), TimePerFloor: e.floorTime,
+		TimePerStop: e.stopTime + e.doorTimes.Opening + e.doorTimes.Dwell + e.doorTimes.Closing}
+	if e.dir == IDLE && (!e.doorsBusy() || est.Pending == 0 || e.floor == pickup.Floor) {
+		// Therefore we have no other requests outstanding: we would go straight there.
+		est.DistanceUntilPickup = e.floor.distance(pickup.Floor)
+		est.GoingThereAnyway = e.floor == pickup.Floor
+		return est
+	}
+
+	sim := &Elevator{id: e.id, numFloors: e.numFloors, floor: e.floor, dest: e.dest, dir: e.dir,
+		dropoffs: e.dropoffs.clone(), pickupsUp: e.pickupsUp.clone(), pickupsDown: e.pickupsDown.clone(),
+		served: e.served, policy: e.policy}
+	sim.pickups(pickup.Dir).set(pickup.Floor)
+	if e.dir == pickup.Dir && (pickup.Floor.between(e.floor, e.dest) || pickup.Floor == e.dest) {
+		// Same test as onPickupReq: we would stop there on the way to our current dest.
+		est.GoingThereAnyway = true
+		sim.dest = pickup.Floor
+	} else if dest, ok := sim.calculateNextStop(); ok && dest == pickup.Floor && e.dir == pickup.Dir && sim.shouldRetarget(dest) {
+		// Same test as onPickupReq: we would carry on past our current dest, where we were only going to turn around.
+		sim.dest = pickup.Floor
+	} else if sim.dir == IDLE {
+		// Our doors are open, and passengers have made requests: when the doors close, we head for the nearest.
+		sim.dest, _ = sim.calculateNextStop()
+		sim.dir = sim.floor.DirectionTo(sim.dest)
+	}
+
+	// Each iteration drives to sim.dest and stops there. Every stop clears at least one request,
+	// so we cannot loop for longer than there are requests.
+	for i := 0; i <= 3*e.numFloors; i++ {
+		est.DistanceUntilPickup += sim.floor.distance(sim.dest)
+		sim.floor = sim.dest
+		if sim.floor == pickup.Floor && sim.dir == pickup.Dir {
+			return est
+		}
+		sim.dropoffs.clear(sim.floor)
+		sim.pickups(sim.dir).clear(sim.floor)
+		est.StopsUntilPickup++
+
+		dest, ok := sim.calculateNextStop()
+		if !ok {
+			break
+		}
+		if dest == sim.floor {
+			// Same special case as onDriveNotification: pickup in the opposite direction at this floor.
+			if sim.floor == pickup.Floor && sim.dir.opposite() == pickup.Dir {
+				est.StopsUntilPickup-- // Same stop, we just turn around.
+				return est
+			}
+			sim.pickups(sim.dir.opposite()).clear(sim.floor)
+			sim.dir = sim.dir.opposite()
+			continue
+		}
+		sim.dest = dest
+		sim.dir = sim.floor.DirectionTo(dest)
+	}
+
+	log.Printf("Elevator-%d WARNING: could not estimate %v\n", e.id, pickup)
+	return est
 }
-*/
 
 func (e *Elevator) onPickupReq(pickup Pickup) {
 	log.Printf("Elevator-%d received req %v\n", e.id, pickup)
 
-	// If we are stopped at this floor, notify the pickup now.
-	if e.dir == IDLE && e.floor == pickup.Floor {
-		log.Printf("Elevator-%d notifying arrival on channel %v", e.id, pickup.Done)
-		go func() {
-			pickup.Done <- Arrival{pickup.Floor, pickup.Dir, e}
-		}()
+	if e.full() || e.outOfGroup() || !e.serves(pickup) {
+		log.Printf("Elevator-%d cannot take %v, returning it\n", e.id, pickup)
+		e.returnPickups(pickup)
 		return
 	}
 
 	e.waiters.addPickupListener(pickup)
+	if pickup.Call != 0 {
+		e.pickupCalls[pickup.FloorDir()] = pickup.Call
+	}
+
+	// If we are stopped at this floor (and not committed to the other direction), serve the pickup
+	// with this door cycle: notify now if the doors are open, else (re)open them.
+	if e.floor == pickup.Floor && (e.dir == IDLE || (e.doorsBusy() && e.dir == pickup.Dir)) {
+		e.dir = pickup.Dir // Rule 6. Our dest is still our floor: we choose the next when the doors close.
+		if e.door == DoorsOpen {
+			e.pickups(pickup.Dir).clear(pickup.Floor)
+			e.arrive(e.arrival(pickup.Dir))
+			e.extendDwell()
+		} else {
+			e.recordPickup(pickup, e.pickups(pickup.Dir).set(pickup.Floor))
+			e.openDoors()
+		}
+		return
+	}
 
-	// If we're already aware of this pickup FloorDir, nothing to do.
-	if e.pickups(pickup.Dir).set(pickup.Floor) { // set() returns previous value.
+	// If we're already aware of this pickup FloorDir, nothing to do (unless it is Aged now: reconsider).
+	had := e.pickups(pickup.Dir).set(pickup.Floor) // set() returns previous value.
+	e.recordPickup(pickup, had)
+	if had && !pickup.Aged {
 		log.Printf("Elevator-%d has this pickup already\n", e.id)
 		return
 	}
 
 	// Decide whether to go to the new pickup instead.
-	if e.dir == IDLE {
+	if e.doorsBusy() {
+		// We choose our next stop when the doors close.
+	} else if e.dir == IDLE {
 		// Therefore we have no other requests outstanding. FUTURE: Assert that.
-		// FUTURE: This will change if delay after a stop to allow passengers to enter dropoff requests.
-		e.gotoFloor(pickup.Floor)
-	} else if e.dir == pickup.Dir && pickup.Floor.between(e.floor, e.dest) {
-		// Pickup lies en route to our current dest, and is the same direction. Let's go there first.
-		// After pickup, we will continue towards to our previous destination.
 		e.gotoFloor(pickup.Floor)
+	} else if dest, ok := e.calculateNextStop(); ok && e.shouldRetarget(dest) {
+		// Either:
+		// - Pickup lies en route to our current dest, and (for CollectiveSelective) is the same direction.
+		//   Let's go there first. After pickup, we will continue towards to our previous destination.
+		// - Pickup lies beyond our current dest, which we were only going to turn around at. Extend the sweep.
+		e.gotoFloor(dest)
 	} else {
 		// Either:
 		// - The new pickup is en route to our current destination, but wants to go the opposite direction.
-		// - The new pickup is beyond our current destination.
+		// - The new pickup is beyond our current destination, where we have something to do.
 		// - The new pickup lies in the opposite direction as our destination.
 		// In these cases, don't change our destination. We'll get to it later.
 	}
@@ -205,152 +541,315 @@ func (e *Elevator) onPickupReq(pickup Pickup) {
 
 func (e *Elevator) onDropoffReq(dropoff Dropoff) {
 	log.Printf("Elevator-%d received req %v\n", e.id, dropoff)
+	e.journal.dropoff(e.id, dropoff)
+	e.events.emit(Event{Kind: EventCarCall, Car: e.id, Floor: dropoff.Floor, Persons: dropoffLoad(dropoff).persons})
+
+	// Passenger boarded: give others time to board too.
+	e.extendDwell()
+
+	if e.withdrawn() {
+		log.Printf("Elevator-%d is in %s mode, refusing %v\n", e.id, e.mode, dropoff)
+		arrival := e.arrival(IDLE)
+		arrival.Outcome = Refused
+		e.notify(dropoff.Done, arrival)
+		return
+	}
+
+	// If we are stopped at this floor, notify the dropoff now.
+	if (e.dir == IDLE || e.doorsBusy()) && e.floor == dropoff.Floor {
+		e.notify(dropoff.Done, e.arrival(IDLE))
+		return
+	}
 
-	// If we are stopped at this floor, notify the pickup now.
-	if e.dir == IDLE && e.floor == dropoff.Floor {
-		log.Printf("Elevator-%d notifying arrival on channel %v", e.id, dropoff.Done)
-		go func() {
-			dropoff.Done <- Arrival{dropoff.Floor, IDLE, e}
-		}()
+	if !e.served.isSet(dropoff.Floor) {
+		log.Printf("Elevator-%d does not serve %v\n", e.id, dropoff)
+		arrival := e.arrival(e.dir)
+		arrival.Outcome = Unserved
+		e.notify(dropoff.Done, arrival)
+		return
+	}
+	if !e.fits(dropoffLoad(dropoff)) {
+		log.Printf("Elevator-%d refused %v: %d persons can never fit\n", e.id, dropoff, dropoffLoad(dropoff).persons)
+		arrival := e.arrival(e.dir)
+		arrival.Outcome = Oversized
+		e.notify(dropoff.Done, arrival)
+		return
+	}
+	if !e.board(dropoff) {
+		arrival := e.arrival(e.dir)
+		arrival.Outcome = Refused
+		e.notify(dropoff.Done, arrival)
 		return
 	}
 
 	e.waiters.addDropoffListener(dropoff)
 
-	if !e.dropoffs.set(dropoff.Floor) { // returns previous value
+	if !e.dropoffs.set(dropoff.Floor) && !e.doorsBusy() { // returns previous value
 		if e.dir == IDLE {
 			e.gotoFloor(dropoff.Floor)
-		} else if dropoff.Floor.between(e.floor, e.dest) {
-			e.gotoFloor(dropoff.Floor)
+		} else if dest, ok := e.calculateNextStop(); ok && e.shouldRetarget(dest) {
+			e.gotoFloor(dest) // The dropoff (or something nearer) lies en route, or beyond a turning point.
+		}
+	}
+}
+
+func (e *Elevator) onPickupCancellation(pickup Pickup) {
+	if !e.pickups(pickup.Dir).clear(pickup.Floor) { // clear() returns previous value.
+		return // Not ours.
+	}
+	log.Printf("Elevator-%d cancelled %v\n", e.id, pickup)
+	e.events.emit(Event{Kind: EventCancellation, Car: e.id, Floor: pickup.Floor, Dir: eventDir(pickup.Dir),
+		Call: e.pickupCalls[pickup.FloorDir()]})
+	delete(e.waiters, pickup.FloorDir())
+
+	if e.dir == IDLE || e.doorsBusy() || pickup.Floor != e.dest {
+		return
+	}
+	e.reconsiderDest() // We were heading there.
+}
+
+// We are on our way to dest. Is there still a reason to stop there? If not, we head for our next stop beyond it,
+// or stop as soon as we can.
+func (e *Elevator) reconsiderDest() {
+	dest, ok := e.calculateNextStop()
+	if ok && dest == e.dest {
+		return
+	}
+	if ok && e.floor.DirectionTo(dest) == e.dir {
+		e.gotoFloor(dest) // Something further ahead.
+	} else {
+		// Nothing ahead: stop as soon as we can, and reconsider there. The drive refuses floors it cannot brake for.
+		for next := e.floor.next(e.dir); next.between(e.floor, e.dest) && e.dest != next; next = next.next(e.dir) {
+			e.gotoFloor(next)
+		}
+	}
+}
+
+// Returns an Arrival of this car at its current floor.
+func (e *Elevator) arrival(dir Direction) Arrival {
+	return Arrival{Floor: e.floor, Dir: dir, Conveyor: e, Load: e.load.persons}
+}
+
+func (e *Elevator) notify(ch chan<- Arrival, arrival Arrival) {
+	log.Printf("Elevator-%d notifying %s arrival on channel %v", e.id, arrival.Outcome, ch)
+	e.emitArrival(arrival)
+	e.life.notify(ch, arrival)
+}
+
+// Notifies the waiters, and the System via chArrivals.
+func (e *Elevator) arrive(arrival Arrival) {
+	e.emitArrival(arrival)
+	e.waiters.notifyArrival(arrival, &e.life)
+	e.life.goroutine(func(p *Participant) {
+		if !expect(e.chArrivals) {
+			p.Idle() // Nobody takes our Arrivals (we have no System): time need not wait.
+		}
+		select {
+		case e.chArrivals <- arrival:
+		case <-e.life.quit: // The System has stopped listening.
+			unexpect(e.chArrivals)
 		}
+	})
+}
+
+func (e *Elevator) emitArrival(arrival Arrival) {
+	ev := Event{Kind: EventArrival, Car: e.id, Floor: arrival.Floor, Dir: eventDir(arrival.Dir),
+		Outcome: arrival.Outcome.String(), Alighted: arrival.Alighted, Load: arrival.Load, Aged: arrival.Aged}
+	if arrival.Dir != IDLE && arrival.Outcome == Served {
+		ev.Call = e.pickupCalls[arrival.FloorDir()]
+		delete(e.pickupCalls, arrival.FloorDir())
 	}
+	e.events.emit(ev)
 }
 
 // onArrival (if s.stopping)
 func (e *Elevator) onDriveNotification(s DriverStopNotification) {
+	e.journal.drive(e.id, s)
+	from := e.floor
 	e.floor = s.floor
+	stopped := e.checkDrive(from, s)
 	if s.stopping {
+		e.events.emit(Event{Kind: EventStop, Car: e.id, Floor: s.floor})
 		if s.floor != e.dest {
 			log.Printf("Elevator-%d WARNING: got stop notification at %s, but dest = %s\n", e.id, s.floor, e.dest)
 		}
-
-		e.dropoffs.clear(e.floor)
-		e.pickups(e.dir).clear(e.floor) // FUTURE: signal correct pickup light to clear.
-
-		arrival := Arrival{e.floor, e.dir, e}
-		e.waiters.notifyArrival(arrival) // Notifies all waiters
-
-		// TODO: Passengers we just picked up have not entered their desired stop.
-		// 		 We should wait for some time before choosing our next stop.
-		//		 But we can't just sleep (that would block receive requests).
-		//		 One approach is to return a timer which we mix into the mainLoop select{}
-		dest, ok := e.calculateNextStop()
-		if ok {
-			// Very special case (ick): the current floor has pickup in opposite direction
-			if dest == e.floor {
-				e.pickups(e.dir.opposite()).clear(e.floor) // FUTURE: signal correct pickup light to clear.
-				e.waiters.notifyArrival(Arrival{e.floor, e.dir.opposite(), e})
-				e.dest = e.floor
-				e.dir = IDLE
-			} else {
-				e.gotoFloor(dest) // sets e.dest, e.dir
-			}
-		} else {
-			e.dest = e.floor
+		if e.withdrawn() {
+			log.Printf("Elevator-%d in %s mode stopped at %s, doors shut\n", e.id, e.mode, s.floor)
 			e.dir = IDLE
+			return
 		}
+		// We serve the floor when the doors open, and choose our next stop when they close.
+		// Meanwhile, passengers have time to board and enter their desired stop.
+		e.turnOnArrival()
+		e.openDoors()
+		return
+	}
+	e.events.emit(Event{Kind: EventPassFloor, Car: e.id, Floor: s.floor, Dir: eventDir(e.dir)})
+	if !stopped {
+		e.onMissedFloor()
+		return
+	}
+	if dest, ok := e.calculateNextStop(); ok && e.shouldRetarget(dest) {
+		// A request came too late for us to brake, and we have passed it: is there another one short of dest?
+		e.gotoFloor(dest)
 	}
 }
 
-// Determines the next stop, based on the current floor, dir, dest + dropoffs & pickups.
-// We will continue in current direction if any dropoffs, pickups lay in that direction.
-// Returns a tuple (next floor, is valid).
-func (e *Elevator) calculateNextStop() (dest Floor, ok bool) {
-	// TODO: What if e.dir == IDLE??
-	if e.dir == IDLE {
-		panic("calculateNextStop when IDLE")
+// Returns true if we should head for dest (our next stop, by calculateNextStop) instead of e.dest: it lies short
+// of e.dest; or beyond it, and we have nothing to do at e.dest in our direction (we were only going to turn
+// around there, see turnOnArrival). The drive refuses if we are too close to e.dest to carry on.
+func (e *Elevator) shouldRetarget(dest Floor) bool {
+	if dest.between(e.floor, e.dest) {
+		return true
 	}
+	return e.dir != IDLE && e.floor.DirectionTo(dest) == e.dir && e.dest.between(e.floor, dest) &&
+		!e.dropoffs.isSet(e.dest) && !e.pickups(e.dir).isSet(e.dest)
+}
 
-	floor := e.floor
-	dir := e.dir
-	dirOpposite := e.dir.opposite()
-
-	// Determine next stop. In priority order, this is:
-
-	// 1. The nearest dropoff or pickup (where pickup.dir == current direction)
-	// 		which lies beyond e.floor in current direction.
-	dest, ok = nearestInFloorSets(floor, dir, e.dropoffs, e.pickups(dir))
-	if ok {
+// We have stopped. If we have no pickup here in our direction, and our next stop would be this floor (so our
+// StopPolicy would turn around for a pickup here, the other way), we turn now: the waiting passengers board
+// with this door cycle, rather than after the doors close and reopen.
+// We also turn for a pickup here if we have nothing else to do here, and no dropoffs ahead: we only stopped
+// because it was too late to pass (see shouldRetarget), so we had better serve it.
+func (e *Elevator) turnOnArrival() {
+	if e.dir == IDLE || e.pickups(e.dir).isSet(e.floor) || !e.pickups(e.dir.opposite()).isSet(e.floor) {
 		return
 	}
-
-	// 2. The furthest pickup (where pickup.dir == OPPOSITE direction)
-	// 		which lies AT OR beyond e.floor in current direction.
-	dest, ok = e.pickups(dirOpposite).furthest(floor, dir)
-	if ok {
-		return
+	dest, ok := e.calculateNextStop()
+	_, dropoffsAhead := e.dropoffs.nearest(e.floor, e.dir)
+	if (ok && dest == e.floor) || (!e.dropoffs.isSet(e.floor) && !dropoffsAhead) {
+		log.Printf("Elevator-%d turning %s at %s\n", e.id, e.dir.opposite(), e.floor)
+		e.dir = e.dir.opposite()
 	}
+}
 
-	// OK, there's in our current direction. Find something in the other direction.
-
-	// 3. The nearest dropoff or pickup (where pickup.dir == OPPOSITE direction)
-	// 		which lies beyond e.floor in OPPOSITE direction
-	dest, ok = nearestInFloorSets(floor, dirOpposite, e.dropoffs, e.pickups(dirOpposite)) // REVIEW: was pickups(dir)
-	if ok {
-		return
+// Determines the next stop, by our StopPolicy, unless a pickup has waited too long. Returns a tuple (next floor, is valid).
+func (e *Elevator) calculateNextStop() (dest Floor, ok bool) {
+	dest, ok = e.policy.NextStop(CarState{Floor: e.floor, Dir: e.dir, Dropoffs: e.dropoffs, PickupsUp: e.pickupsUp,
+		PickupsDown: e.pickupsDown, Served: e.served})
+	aged, found := e.agedPickup()
+	if !found || (e.dir == IDLE && aged.floor == e.floor) {
+		return dest, ok
+	}
+	if aged.floor == e.floor {
+		return e.floor, true // The aged pickup is here, the other way: turn around (see departFloor).
+	}
+	if ok && dest.between(e.floor, aged.floor) && e.floor.DirectionTo(dest) == e.dir {
+		return dest, ok // On our way there.
 	}
+	if dest != aged.floor {
+		log.Printf("Elevator-%d heading for %v first: it has waited too long\n", e.id, aged)
+	}
+	return aged.floor, true
+}
 
-	// 4. The furthest pickup (where pickup.dir == current direction)
-	// 		which lies AT OR beyond e.floor in OPPOSITE direction
-	//	  E.g., if dir == DOWN, find the highest pickup.
-	dest, ok = e.pickups(dir).furthest(floor, dirOpposite) // REVIEW: was pickups(dirOpposite)
-	if ok {
-		return
+// Notes when a pickup was called, unless we have it already (had) from earlier. An Aged pickup is overdue at once.
+func (e *Elevator) recordPickup(pickup Pickup, had bool) {
+	since := pickup.Since
+	if since.IsZero() {
+		since = e.clock.Now()
+	}
+	if pickup.Aged {
+		since = time.Time{}
+	}
+	if old, ok := e.pickupSince[pickup.FloorDir()]; !had || !ok || since.Before(old) {
+		e.pickupSince[pickup.FloorDir()] = since
 	}
+}
 
-	// FUTURE: Does that cover all cases?
+// Returns true if the pickup has waited longer than MaxWait (or was Aged by the System).
+func (e *Elevator) overdue(floorDir FloorDir) bool {
+	since, ok := e.pickupSince[floorDir]
+	return ok && (since.IsZero() || e.maxWait > 0 && e.clock.Now().Sub(since) > e.maxWait)
+}
 
-	return InvalidFloor, false
+// The aging rule: returns the pickup which has waited longest, if it has waited too long. Ties go to the lowest floor.
+// We make it before any other request. Not while we are full: we could not take the passengers.
+func (e *Elevator) agedPickup() (FloorDir, bool) {
+	if len(e.pickupSince) == 0 || e.full() {
+		return FloorDir{}, false // Includes the copy in estimatePickup, which ignores aging.
+	}
+	var oldest FloorDir
+	found := false
+	for floorDir, since := range e.pickupSince {
+		if !e.pickups(floorDir.dir).isSet(floorDir.floor) {
+			delete(e.pickupSince, floorDir) // Made or cancelled since.
+			continue
+		}
+		if !e.overdue(floorDir) {
+			continue
+		}
+		if old := e.pickupSince[oldest]; !found || since.Before(old) ||
+			since.Equal(old) && (floorDir.floor < oldest.floor || floorDir.floor == oldest.floor && floorDir.dir < oldest.dir) {
+			oldest, found = floorDir, true
+		}
+	}
+	return oldest, found
 }
 
 // Keeps track of those waiting for an Arrival
-type ArrivalListeners map[FloorDir][]chan<- Arrival // Tracks for each FloorDir
+type ArrivalListeners map[FloorDir][]arrivalListener // Tracks for each FloorDir
+
+// A client's Done channel, and the id it gave it (see Pickup.Listener), for Snapshots. For a Pickup, also the
+// Dests and Persons it gave, should the pickup be handed back (see removePickups).
+type arrivalListener struct {
+	ch      chan<- Arrival
+	id      string
+	dests   []Floor
+	persons int
+}
 
 func (m ArrivalListeners) addDropoffListener(dropoff Dropoff) {
-	m._addListener(FloorDir{dropoff.Floor, IDLE}, dropoff.Done)
+	m._addListener(FloorDir{dropoff.Floor, IDLE}, arrivalListener{ch: dropoff.Done, id: dropoff.Listener})
 }
 func (m ArrivalListeners) addPickupListener(pickup Pickup) {
-	m._addListener(FloorDir{pickup.Floor, pickup.Dir}, pickup.Done)
+	m._addListener(FloorDir{pickup.Floor, pickup.Dir}, arrivalListener{ch: pickup.Done, id: pickup.Listener,
+		dests: pickup.Dests, persons: pickup.Persons})
 }
-func (m ArrivalListeners) _addListener(floorDir FloorDir, listener chan<- Arrival) {
+func (m ArrivalListeners) _addListener(floorDir FloorDir, listener arrivalListener) {
+	if listener.ch == nil {
+		return // The System does not listen for Pickups it dispatches. See Conveyor.Arrivals().
+	}
 	arr := m[floorDir]
 	if arr == nil {
-		arr = make([]chan<- Arrival, 0)
+		arr = make([]arrivalListener, 0)
 	}
 	arr = append(arr, listener)
 	m[floorDir] = arr
 }
-func (m ArrivalListeners) notifyArrival(arrival Arrival) {
+
+// Removes the listeners of a pickup. Returns one Pickup per listener (or one without listener), to be made elsewhere.
+func (m ArrivalListeners) removePickups(floorDir FloorDir) []Pickup {
+	var pickups []Pickup
+	for _, l := range m[floorDir] {
+		pickups = append(pickups, Pickup{Floor: floorDir.floor, Dir: floorDir.dir, Dests: l.dests, Persons: l.persons,
+			Done: l.ch, Listener: l.id})
+	}
+	delete(m, floorDir)
+	if len(pickups) == 0 {
+		pickups = append(pickups, Pickup{Floor: floorDir.floor, Dir: floorDir.dir})
+	}
+	return pickups
+}
+
+func (m ArrivalListeners) notifyArrival(arrival Arrival, life *lifecycle) {
 	// Notify dropoffs.
-	m._notify(FloorDir{arrival.Floor, IDLE}, arrival)
+	m._notify(FloorDir{arrival.Floor, IDLE}, arrival, life)
 
 	// Notify pickups iff we have a direction.
 	if arrival.Dir != IDLE {
 		// Notify pickups
-		m._notify(FloorDir{arrival.Floor, arrival.Dir}, arrival)
+		m._notify(FloorDir{arrival.Floor, arrival.Dir}, arrival, life)
 	}
 }
 
 // Notifies the Pickup and Dropoff listeners.
-func (m ArrivalListeners) _notify(floorDir FloorDir, arrival Arrival) {
+func (m ArrivalListeners) _notify(floorDir FloorDir, arrival Arrival, life *lifecycle) {
 	arr := m[floorDir]
 	if arr != nil {
-		for _, ch := range arr {
-			log.Printf("Elevator-%d notifying arrival on channel %v", arrival.Conveyor.Id(), ch)
-			chSend := ch
-			go func() {
-				chSend <- arrival // FUTURE: Handle closed channel
-			}()
+		for _, l := range arr {
+			log.Printf("Elevator-%d notifying arrival on channel %v", arrival.Conveyor.Id(), l.ch)
+			life.notify(l.ch, arrival) // FUTURE: Handle closed channel
 		}
 		m[floorDir] = nil
 	}
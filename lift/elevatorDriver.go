@@ -3,7 +3,7 @@ package lift
 import (
 	//	"fmt"
 	"log"
-	"os"
+	"math"
 	"time"
 )
 
@@ -11,9 +11,10 @@ import (
 // It knows its direction, last floor (passed if dir!=IDLE) and destination floor.
 // On request:
 // It's not possible to change the elevator's direction when it's underway to a destination.
-// However, it is allowed to change the destination to a floor between the last floor and the dest. (stopping short).
+// However, it is allowed to change the destination to another floor ahead (stopping short, or going further),
+// as long as the car can still brake for it (see MotionProfile).
 // On request, if it can stop at the new floor, it sets it as new destination and returns this value.
-// If it cannot (because it has passed the floor, or (FUTURE) because it is approaching the floor too fast to stop),
+// If it cannot (because it has passed the floor, or because it is approaching the floor too fast to stop),
 // then a request does not change its destination, and returns the old value.
 type elevatorDriver struct {
 	id              int
@@ -22,12 +23,75 @@ type elevatorDriver struct {
 	dir             Direction                   // If IDLE, not moving. Always, dir == floor.directionTo(dest).
 	chRequests      chan DriverDestRequest      // We receive requests here
 	chNotifications chan DriverStopNotification // We send notifications here
+	clock           Clock
+	motion          MotionProfile
+	levels          []float64 // The height of each floor, in metres.
+	origin          Floor     // Where the current run started.
+	started         time.Time // When the current run started.
+	run             motionRun // From origin to dest.
+	// Notifications not yet received by the Elevator. We never block sending them: the Elevator may be
+	// sending us a request at the same time.
+	pending    []DriverStopNotification
+	chSnapshot chan chan<- DriveSnapshot // The Elevator asks for our DriveSnapshot.
+	timer      <-chan time.Time          // Fires when we reach the next floor. nil at rest, or while stuck.
+	// Injected faults (see Fault). The Elevator is not told of them: it must notice.
+	chFaults   chan Fault
+	speed      float64   // The fraction of its motion profile the drive keeps up: 1, unless FaultSlow.
+	stuckSince time.Time // When FaultStuck stopped us. Zero if it has not.
+	missNext   bool      // FaultMissedFloor: we will run past our next dest, and stop at the floor beyond.
+	life       lifecycle
 }
 
-func newDriver(id int) *elevatorDriver {
-	d := &elevatorDriver{id, 0, 0, IDLE, make(chan DriverDestRequest), make(chan DriverStopNotification)}
-	go d.mainLoop(0)
-	return d
+// Returns a driver at rest at the bottom floor. It does not run until start.
+func newDriver(id int, motion MotionProfile, levels []float64, clock Clock) *elevatorDriver {
+	return &elevatorDriver{id: id, floor: 0, dest: 0, dir: IDLE, chRequests: make(chan DriverDestRequest),
+		chNotifications: make(chan DriverStopNotification), clock: clock, motion: motion, levels: levels,
+		chSnapshot: make(chan chan<- DriveSnapshot), chFaults: make(chan Fault), speed: 1,
+		life: lifecycle{quit: make(chan struct{}), clock: clock}}
+}
+
+func (d *elevatorDriver) start() {
+	d.life.goroutine(d.mainLoop, d.chRequests, d.chFaults, d.chSnapshot)
+}
+
+// Stops the driver where it is (between floors, if moving). Notifications not yet received are dropped.
+func (d *elevatorDriver) close() { d.life.close() }
+
+// Returns the run from our origin to the floor.
+func (d *elevatorDriver) runTo(f Floor) motionRun {
+	return newMotionRun(d.motion, math.Abs(d.levels[f]-d.levels[d.origin]))
+}
+
+// Returns a timer which fires when we reach the next floor on our run; or nil, if we are stuck.
+func (d *elevatorDriver) nextFloorTimer() <-chan time.Time {
+	if !d.stuckSince.IsZero() {
+		return nil
+	}
+	next := d.floor.next(d.dir)
+	at := d.run.timeAt(math.Abs(d.levels[next] - d.levels[d.origin]))
+	return d.clock.After(d.started.Add(seconds(at / d.speed)).Sub(d.clock.Now()))
+}
+
+// Returns how far into our run we are, in seconds of the motion profile: real time, unless we are slow or stuck.
+func (d *elevatorDriver) elapsed() float64 { return d.now().Sub(d.started).Seconds() * d.speed }
+
+// Returns the time, as far as our run is concerned: it stands still while we are stuck.
+func (d *elevatorDriver) now() time.Time {
+	if d.stuckSince.IsZero() {
+		return d.clock.Now()
+	}
+	if d.stuckSince.Before(d.started) {
+		return d.started
+	}
+	return d.stuckSince
+}
+
+// Returns how long a run from origin to dest should take, from floor from to floor to. It only reads what never
+// changes, so the Elevator may call it, to know when to expect us.
+func (d *elevatorDriver) expectedTime(origin, dest, from, to Floor) time.Duration {
+	run := newMotionRun(d.motion, math.Abs(d.levels[dest]-d.levels[origin]))
+	return seconds(run.timeAt(math.Abs(d.levels[to]-d.levels[origin])) -
+		run.timeAt(math.Abs(d.levels[from]-d.levels[origin])))
 }
 
 type DriverDestRequest struct {
@@ -40,9 +104,21 @@ type DriverStopNotification struct {
 	stopping bool
 }
 
-func (d *elevatorDriver) mainLoop(floor Floor) {
-	var timer <-chan time.Time
+func (d *elevatorDriver) mainLoop(p *Participant) {
+	if d.dir != IDLE {
+		d.timer = d.nextFloorTimer() // Restored part way through a run.
+	}
+	for range d.pending {
+		p.offer(d.chNotifications)
+	}
 	for {
+		var chNotifications chan DriverStopNotification // nil (disabled) unless there is something to send
+		var next DriverStopNotification
+		if len(d.pending) > 0 {
+			chNotifications, next = d.chNotifications, d.pending[0]
+		}
+
+		p.Idle(d.timer)
 		select {
 		case req := <-d.chRequests:
 			// Request to set/change destination.
@@ -51,36 +127,92 @@ func (d *elevatorDriver) mainLoop(floor Floor) {
 					d.dest = req.floor
 					d.dir = d.floor.DirectionTo(d.dest)
 					// start moving
-					timer = time.After(TimeBetweenFloors) // FUTURE: set speed
-					log.Printf("Elevator-%d at %s going %s to %s\n", d.id, d.floor, d.dir, d.dest)
+					d.origin, d.started = d.floor, d.clock.Now()
+					d.run = d.runTo(d.dest)
+					d.timer = d.nextFloorTimer()
+					log.Printf("Elevator-%d at %s going %s to %s, arriving in %v\n", d.id, d.floor, d.dir, d.dest,
+						seconds(d.run.duration()).Round(time.Millisecond))
+				}
+			} else if req.floor != d.dest && d.floor.DirectionTo(req.floor) == d.dir {
+				// New floor is ahead, short of or beyond our current dest. We can go there if we have not yet
+				// begun to brake for either floor: until then, both runs are the same.
+				run := d.runTo(req.floor)
+				if d.run.canSwitch(d.elapsed(), run) {
+					log.Printf("Elevator-%d going %s changed destination from %s to %s\n", d.id, d.dir, d.dest, req.floor)
+					d.dest, d.run = req.floor, run
+					d.timer = d.nextFloorTimer()
+				} else {
+					log.Printf("Elevator-%d going %s to %s cannot brake for %s\n", d.id, d.dir, d.dest, req.floor)
 				}
-			} else if req.floor.between(d.floor, d.dest) {
-				// New floor is on route to current dest.
-				// FUTURE: check if we can slow down in time. Reduce speed if needed.
-				log.Printf("Elevator-%d going %s changed destination from %s to %s\n", d.id, d.dir, d.dest, req.floor)
-				d.dest = req.floor
-			} else if floor.DirectionTo(req.floor) == d.dir {
-				// New floor is beyond our current dest in the same direction. Go there.
-				// FUTURE: Increase speed if needed.
-				log.Printf("Elevator-%d going %s changed destination from %s to %s\n", d.id, d.dir, d.dest, req.floor)
-				d.dest = req.floor
 			}
 			req.chReply <- d.dest
 
-		case <-timer:
+		case <-d.timer:
 			// Passing or stopping at a floor.
 			d.floor = d.floor.next(d.dir) // I.e.: d.floor += d.dir
+			if d.floor == d.dest && d.missNext && d.inRange(d.floor.next(d.dir)) {
+				// We fail to stop, and carry on to the next floor, as if we had set off from here.
+				log.Printf("Elevator-%d FAULT: failed to stop at %s\n", d.id, d.floor)
+				d.missNext = false
+				d.dest = d.floor.next(d.dir)
+				d.origin, d.started = d.floor, d.clock.Now()
+				d.run = d.runTo(d.dest)
+			}
 			if d.floor == d.dest {
 				log.Printf("Elevator-%d stopped at %d\n", d.id, d.floor)
 				d.dir = IDLE // stop
-				timer = nil
+				d.timer = nil
 			} else {
 				log.Printf("Elevator-%d passing %s %s\n", d.id, d.floor, d.dir)
-				timer = time.After(TimeBetweenFloors)
+				d.timer = d.nextFloorTimer()
 			}
-			d.chNotifications <- DriverStopNotification{d.floor, d.floor == d.dest}
+			d.pending = append(d.pending, DriverStopNotification{d.floor, d.floor == d.dest})
+			p.offer(d.chNotifications)
+
+		case f := <-d.chFaults:
+			d.onFault(f)
+
+		case chNotifications <- next:
+			d.pending = d.pending[1:]
+
+		case chReply := <-d.chSnapshot:
+			chReply <- d.snapshot()
+
+		case <-d.life.quit:
+			log.Printf("Elevator-%d driver stopped at %s\n", d.id, d.floor)
+			return
 		}
 	}
+}
 
-	os.Exit(0)
+func (d *elevatorDriver) inRange(f Floor) bool { return f >= 0 && int(f) < len(d.levels) }
+
+// Applies an injected fault (or clears it). The drive carries on from where the fault left it.
+func (d *elevatorDriver) onFault(f Fault) {
+	log.Printf("Elevator-%d drive: injected %v\n", d.id, f)
+	// We keep our place in the run: elapsed() is the same before and after.
+	elapsed := d.elapsed()
+	switch f.Kind {
+	case FaultStuck:
+		if f.Clear == d.stuckSince.IsZero() {
+			return // Not stuck, or stuck already.
+		}
+		if f.Clear {
+			d.stuckSince = time.Time{}
+		} else {
+			d.stuckSince = d.clock.Now()
+		}
+	case FaultSlow:
+		d.speed = 1
+		if !f.Clear {
+			d.speed = f.factor()
+		}
+	case FaultMissedFloor:
+		d.missNext = !f.Clear
+		return
+	}
+	if d.dir != IDLE {
+		d.started = d.now().Add(-seconds(elapsed / d.speed))
+		d.timer = d.nextFloorTimer()
+	}
 }
@@ -0,0 +1,122 @@
+package lift
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// What an Event records.
+type EventKind string
+
+const (
+	EventHallCall     EventKind = "hall-call"     // A passenger called at Floor, going Dir. Passengers who call while the call is outstanding join it.
+	EventAssignment   EventKind = "assignment"    // The System sent the hall call to Car: again if the car handed it back, or the call was escalated (Aged).
+	EventCarCall      EventKind = "car-call"      // Persons aboard Car pressed the button for Floor.
+	EventDeparture    EventKind = "departure"     // Car left Floor, where it was at rest, going Dir to Dest.
+	EventPassFloor    EventKind = "pass-floor"    // Car passed Floor, going Dir.
+	EventStop         EventKind = "stop"          // Car stopped at Floor.
+	EventDoorsOpening EventKind = "doors-opening" //
+	EventDoorsOpen    EventKind = "doors-open"    //
+	EventDoorsClosing EventKind = "doors-closing" //
+	EventDoorsClosed  EventKind = "doors-closed"  //
+	EventArrival      EventKind = "arrival"       // Car notified the passengers at Floor going Dir (or, without Dir, those aboard for Floor), with Outcome.
+	EventCancellation EventKind = "cancellation"  // Car (or, if Car is -1, the System) dropped a call: another car made it, or the System was closed.
+	EventServed       EventKind = "served"        // Car made the pickup of hall call Call, at Floor going Dir: the System cleared it. Whichever car it was sent to.
+	EventFault        EventKind = "fault"         // Car detected a Fault (see FaultKind) at Floor, with Load aboard.
+	EventFaultCleared EventKind = "fault-cleared" // Car is clear of the Fault.
+	EventMode         EventKind = "mode"          // Car changed to Mode (see CarMode) at Floor, with Load aboard.
+)
+
+// An Event is a record of something which happened in a System, for tools which read the log rather than
+// the text of log.Printf. Its JSON form (see JSONLines) is stable: fields which do not apply to the Kind are
+// zero, and omitted.
+type Event struct {
+	Seq      int64     `json:"seq"`  // From 1, in the order the Events happened.
+	Time     time.Time `json:"time"` // By the System's Clock.
+	Kind     EventKind `json:"kind"`
+	Car      int       `json:"car"` // The car's id, or -1 for none.
+	Floor    Floor     `json:"floor"`
+	Dir      string    `json:"dir,omitempty"`  // "UP" or "DOWN".
+	Call     int64     `json:"call,omitempty"` // The hall call's id, from 1. See Pickup.Call.
+	Dest     *Floor    `json:"dest,omitempty"`
+	Outcome  string    `json:"outcome,omitempty"` // See Outcome.
+	Persons  int       `json:"persons,omitempty"`
+	Alighted int       `json:"alighted,omitempty"`
+	Load     int       `json:"load,omitempty"`  // Passengers aboard Car, after those alighted.
+	Aged     bool      `json:"aged,omitempty"`  // See CarSpec.MaxWait.
+	Fault    string    `json:"fault,omitempty"` // See FaultKind.
+	Mode     string    `json:"mode,omitempty"`  // See CarMode.
+}
+
+// An EventSink receives every Event of a System, one at a time, in order.
+type EventSink interface {
+	Event(Event)
+}
+
+// An EventSinkFunc is a function used as an EventSink.
+type EventSinkFunc func(Event)
+
+func (f EventSinkFunc) Event(ev Event) { f(ev) }
+
+// A MultiSink sends each Event to all of its EventSinks, in turn.
+type MultiSink []EventSink
+
+func (ms MultiSink) Event(ev Event) {
+	for _, sink := range ms {
+		sink.Event(ev)
+	}
+}
+
+// JSONLines is an EventSink which writes each Event to a Writer as one line of JSON.
+type JSONLines struct {
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLines(w io.Writer) *JSONLines { return &JSONLines{enc: json.NewEncoder(w)} }
+
+func (j *JSONLines) Event(ev Event) {
+	if j.err == nil {
+		j.err = j.enc.Encode(ev)
+	}
+}
+
+// Returns the first error writing an Event, if any. Events after it are dropped.
+func (j *JSONLines) Err() error { return j.err }
+
+// Numbers and timestamps the Events of a System and its Elevators (which emit them from their own goroutines),
+// and hands them to the sink one at a time. A nil *eventLog drops them.
+type eventLog struct {
+	mu    sync.Mutex
+	sink  EventSink
+	clock Clock
+	seq   int64
+}
+
+func newEventLog(sink EventSink, clock Clock) *eventLog {
+	if sink == nil {
+		return nil
+	}
+	return &eventLog{sink: sink, clock: clock}
+}
+
+func (l *eventLog) emit(ev Event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	ev.Seq, ev.Time = l.seq, l.clock.Now()
+	l.sink.Event(ev)
+}
+
+// Returns the direction as an Event records it: "UP", "DOWN", or empty for IDLE.
+func eventDir(dir Direction) string {
+	if dir == IDLE {
+		return ""
+	}
+	return dir.String()
+}
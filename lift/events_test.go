@@ -0,0 +1,72 @@
+package lift
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects the Events of a System.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) Event(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// Returns the Events of the kind so far.
+func (r *eventRecorder) of(kind EventKind) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []Event
+	for _, ev := range r.events {
+		if ev.Kind == kind {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// A car the hall call was not sent to makes the pickup, on its way to a dropoff: the System's served Event links
+// the call to it.
+func TestServedByOtherCar(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	events := &eventRecorder{}
+	s := NewSystem(6, DefaultCarSpecs(2), firstDispatcher{}, clock, events, nil)
+	defer s.Close()
+	p := Join(clock)
+	defer p.Leave()
+
+	// Elevator-1 sets off for 3 and 5. Two seconds later, a hall call at 3 UP goes to Elevator-0, at 0.
+	for _, f := range []Floor{3, 5} {
+		if err := SendDropoff(s.Conveyors()[1], Dropoff{Floor: f, Done: make(chan Arrival, 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Sleep(2 * time.Second)
+	done := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Done: done}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 0 {
+		t.Fatalf("3 UP went to Elevator-%d, want Elevator-0", got)
+	}
+	awaitArrival(t, p, done, 1, "3 UP")
+
+	calls := events.of(EventHallCall)
+	if len(calls) != 1 {
+		t.Fatalf("%d hall-call events, want 1", len(calls))
+	}
+	served := events.of(EventServed)
+	if len(served) != 1 {
+		t.Fatalf("%d served events, want 1", len(served))
+	}
+	if ev := served[0]; ev.Call != calls[0].Call || ev.Car != 1 || ev.Floor != 3 || ev.Dir != "UP" {
+		t.Errorf("served %+v, want call %d by car 1 at 3 UP", ev, calls[0].Call)
+	}
+}
@@ -0,0 +1,304 @@
+package lift
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Fault injection: the ways a car's hardware may fail, so that we can see how the controller copes.
+// A Fault is injected into the hardware (the drive, or the doors), not into the Elevator: the Elevator must notice
+// it from what it observes, as a real controller would. Then it hands its hall calls back to the System, for
+// dispatch to the other cars, and reports the fault on its Faults channel, and as an Event.
+type FaultKind string
+
+const (
+	// The drive stops between floors. Detected when the car does not reach the next floor in time.
+	FaultStuck FaultKind = "stuck"
+	// The drive runs at a fraction of its speed (see Fault.Factor). Detected from the time between floors.
+	// The car still takes hall calls.
+	FaultSlow FaultKind = "slow"
+	// The drive fails to stop at its next destination, and stops at the floor beyond. Detected when the car passes
+	// its destination. It lasts for one stop: there is nothing to clear.
+	FaultMissedFloor FaultKind = "missed-floor"
+	// The doors will not close. Detected when they have failed to close DoorJamAttempts times.
+	FaultDoorJam FaultKind = "door-jam"
+	// The car reports itself out of service (e.g., a safety circuit opened). It sets down its passengers, but takes
+	// no hall calls until the fault clears.
+	FaultOutOfService FaultKind = "out-of-service"
+)
+
+var FaultKinds = []FaultKind{FaultStuck, FaultSlow, FaultMissedFloor, FaultDoorJam, FaultOutOfService}
+
+const (
+	DefaultSlowFactor = 0.5
+	MinSlowFactor     = 0.3 // Slower than this, the car would look stuck: see stuckMargin.
+	DoorJamAttempts   = 3
+	DoorJamRetry      = 20 * time.Second // How long jammed doors stay open before we try to close them again.
+	stuckMargin       = 4                // The car is stuck if it takes this many times longer than it should to reach a floor.
+	slowMargin        = 1.25             // The car is slow if it takes this many times longer than it should between floors.
+)
+
+// A fault to inject into (or, if Clear, to clear from) car Car. See System.InjectFault.
+type Fault struct {
+	Kind   FaultKind `json:"fault"`
+	Car    int       `json:"car"`
+	Factor float64   `json:"factor,omitempty"` // FaultSlow: the fraction of its speed the drive keeps. Zero means DefaultSlowFactor.
+	Clear  bool      `json:"clear,omitempty"`
+}
+
+func (f Fault) String() string {
+	if f.Clear {
+		return fmt.Sprintf("Fault(%s cleared, car %d)", f.Kind, f.Car)
+	}
+	return fmt.Sprintf("Fault(%s, car %d)", f.Kind, f.Car)
+}
+
+func (f Fault) factor() float64 {
+	if f.Factor == 0 {
+		return DefaultSlowFactor
+	}
+	return f.Factor
+}
+
+// Returns an error if the Fault is of no known kind, or its Factor is out of range.
+func (f Fault) Validate() error {
+	found := false
+	for _, kind := range FaultKinds {
+		found = found || f.Kind == kind
+	}
+	if !found {
+		return fmt.Errorf("unknown fault %q: must be one of %v", f.Kind, FaultKinds)
+	}
+	if f.Kind == FaultSlow && (f.factor() < MinSlowFactor || f.factor() > 1) {
+		return fmt.Errorf("slow fault: factor %v must be from %v to 1", f.Factor, MinSlowFactor)
+	}
+	return nil
+}
+
+// A fault which a car detected (or saw clear). See Conveyor.Faults.
+type FaultReport struct {
+	Car     int
+	Kind    FaultKind
+	Floor   Floor // Where the car was: the last floor it passed, if it was moving.
+	Cleared bool
+}
+
+// How many faults were injected into the cars, and how many the cars detected, and saw clear. See System.Faults.
+type FaultStats struct {
+	Injected int64
+	Detected int64
+	Cleared  int64
+}
+
+// Returns true if a fault stops us taking hall calls.
+func (e *Elevator) faulted() bool {
+	return e.faults[FaultStuck] || e.faults[FaultDoorJam] || e.faults[FaultOutOfService]
+}
+
+// Returns our faults, in the order of FaultKinds. For CarStatus.
+func (e *Elevator) faultKinds() []FaultKind {
+	var kinds []FaultKind
+	for _, kind := range FaultKinds {
+		if e.faults[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// A fault is injected into our hardware. Those of the drive go to the drive, and we find out when it misbehaves.
+// Jammed doors we find out about when they fail to close. But the car tells us when it is out of service.
+func (e *Elevator) onInjectedFault(f Fault) {
+	switch f.Kind {
+	case FaultStuck, FaultSlow, FaultMissedFloor:
+		expect(e.drive.chFaults)
+		e.drive.chFaults <- f
+	case FaultDoorJam:
+		log.Printf("Elevator-%d doors: injected %v\n", e.id, f)
+		e.jammed = !f.Clear
+	case FaultOutOfService:
+		if f.Clear {
+			e.clearFault(FaultOutOfService)
+		} else {
+			e.detectFault(FaultOutOfService)
+		}
+	}
+}
+
+// Records a fault, unless we know of it already. If we cannot take hall calls now, we hand ours back.
+func (e *Elevator) detectFault(kind FaultKind) {
+	if e.faults[kind] {
+		return
+	}
+	log.Printf("Elevator-%d FAULT detected: %s at %s\n", e.id, kind, e.floor)
+	if kind != FaultMissedFloor {
+		e.faults[kind] = true
+	}
+	e.reportFault(kind, false)
+	if e.faulted() {
+		e.bypassPickups("faulted")
+	}
+}
+
+func (e *Elevator) clearFault(kind FaultKind) {
+	if !e.faults[kind] {
+		return
+	}
+	log.Printf("Elevator-%d fault cleared: %s at %s\n", e.id, kind, e.floor)
+	delete(e.faults, kind)
+	e.reportFault(kind, true)
+}
+
+func (e *Elevator) reportFault(kind FaultKind, cleared bool) {
+	ev := Event{Kind: EventFault, Car: e.id, Floor: e.floor, Fault: string(kind), Load: e.load.persons}
+	if cleared {
+		ev.Kind = EventFaultCleared
+	}
+	e.events.emit(ev)
+	e.pendingFaults = append(e.pendingFaults, FaultReport{Car: e.id, Kind: kind, Floor: e.floor, Cleared: cleared})
+	e.part.offer(e.chFaults)
+}
+
+// The drive set off on a new run (from rest, or again from where it missed its stop).
+func (e *Elevator) watchRun() {
+	e.runOrigin, e.lastFloorAt = e.floor, e.clock.Now()
+	e.armWatchdog()
+}
+
+// Sets the watchdog to fire if the drive does not reach the next floor in good time.
+func (e *Elevator) armWatchdog() {
+	if e.dir == IDLE || e.lastFloorAt.IsZero() {
+		e.watchdog = nil
+		return
+	}
+	expected := e.drive.expectedTime(e.runOrigin, e.dest, e.floor, e.floor.next(e.dir))
+	deadline := e.lastFloorAt.Add(stuckMargin*expected + Tick)
+	e.watchdog = e.clock.After(deadline.Sub(e.clock.Now()))
+}
+
+// The watchdog fired: the drive has not reached the next floor. It is stuck.
+func (e *Elevator) onWatchdog() {
+	e.watchdog = nil
+	if e.dir != IDLE {
+		e.detectFault(FaultStuck)
+	}
+}
+
+// The drive reached e.floor, from the floor before. Checks how long it took, and whether it stopped where we asked.
+// Returns false if it failed to stop.
+func (e *Elevator) checkDrive(from Floor, s DriverStopNotification) bool {
+	now := e.clock.Now()
+	if e.faults[FaultStuck] {
+		e.clearFault(FaultStuck) // It took as long as it was stuck: that says nothing about its speed.
+	} else if !e.lastFloorAt.IsZero() {
+		expected := e.drive.expectedTime(e.runOrigin, e.dest, from, s.floor)
+		if took := now.Sub(e.lastFloorAt); took > time.Duration(slowMargin*float64(expected))+time.Millisecond {
+			if !e.faults[FaultSlow] {
+				log.Printf("Elevator-%d took %v from %s to %s, expected %v\n", e.id, took, from, s.floor, expected)
+			}
+			e.detectFault(FaultSlow)
+		} else {
+			e.clearFault(FaultSlow)
+		}
+	}
+	e.lastFloorAt = now
+	if s.stopping {
+		e.watchdog = nil
+		return true
+	}
+	if s.floor == e.dest {
+		return false
+	}
+	e.armWatchdog()
+	return true
+}
+
+// The drive passed our dest without stopping. It stops at the floor beyond: we hand back the pickups we were going
+// to make here (the System may well give them back to us), but keep the dropoffs. We come back for them.
+func (e *Elevator) onMissedFloor() {
+	e.detectFault(FaultMissedFloor)
+	var returns []Pickup
+	for _, dir := range []Direction{UP, DOWN} {
+		returns = append(returns, e.takeBackPickups(e.floor, dir)...)
+	}
+	if len(returns) > 0 {
+		log.Printf("Elevator-%d missed %s, returning %v\n", e.id, e.floor, returns)
+		e.returnPickups(returns...)
+	}
+	e.gotoFloor(e.floor.next(e.dir)) // Where the drive is heading now.
+	e.watchRun()
+}
+
+// The doors failed to close, as they are jammed. We reopen them. After DoorJamAttempts, the doors are jammed:
+// we leave them open, and try again every DoorJamRetry.
+func (e *Elevator) onDoorsJammed() {
+	e.closeTries++
+	log.Printf("Elevator-%d doors failed to close at %s (%d times)\n", e.id, e.floor, e.closeTries)
+	if e.closeTries >= DoorJamAttempts {
+		e.detectFault(FaultDoorJam)
+	}
+	e.setDoors(DoorsOpening, e.doorTimes.Opening)
+}
+
+// The System records the faults its cars report, and when one clears, it tries again the hall calls no car could
+// take. The cars hand back their hall calls themselves (see detectFault).
+func (s *System) onFault(report FaultReport) {
+	if report.Cleared {
+		log.Printf("System: Elevator-%d is clear of %s\n", report.Car, report.Kind)
+		atomic.AddInt64(&s.faults.Cleared, 1)
+		s.retryUnassigned()
+		return
+	}
+	log.Printf("System: Elevator-%d has %s at %s\n", report.Car, report.Kind, report.Floor)
+	atomic.AddInt64(&s.faults.Detected, 1)
+}
+
+// Injects the fault into car f.Car (see Fault), and once the car has it, records it in the journal. Safe to call
+// from any goroutine. After Close, it returns ErrClosed.
+func (s *System) InjectFault(f Fault) error {
+	if f.Car < 0 || f.Car >= len(s.elevators) {
+		return fmt.Errorf("no car %d", f.Car)
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	expect(s.elevators[f.Car].FaultInjections())
+	select {
+	case s.elevators[f.Car].FaultInjections() <- f:
+	case <-s.life.quit:
+		unexpect(s.elevators[f.Car].FaultInjections())
+		return ErrClosed
+	}
+	s.journal.fault(f)
+	if !f.Clear {
+		atomic.AddInt64(&s.faults.Injected, 1)
+	}
+	return nil
+}
+
+// Returns how many faults have been injected, detected and cleared so far. Safe to call from any goroutine.
+func (s *System) Faults() FaultStats {
+	return FaultStats{atomic.LoadInt64(&s.faults.Injected), atomic.LoadInt64(&s.faults.Detected),
+		atomic.LoadInt64(&s.faults.Cleared)}
+}
+
+// Merges the FaultReports of one elevator into s.chFaults.
+func (s *System) forwardFaults(p *Participant, e Conveyor) {
+	for {
+		p.Idle()
+		select {
+		case report := <-e.Faults():
+			expect(s.chFaults)
+			select {
+			case s.chFaults <- report:
+			case <-s.life.quit:
+				unexpect(s.chFaults)
+				return
+			}
+		case <-s.life.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package lift
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a System of numCars default cars, as testSystem, and the recorder of its Events.
+func faultSystem(numFloors, numCars int) (*System, *eventRecorder, *Participant, func()) {
+	clock := NewVirtualClock(Epoch)
+	events := &eventRecorder{}
+	s := NewSystem(numFloors, DefaultCarSpecs(numCars), firstDispatcher{}, clock, events, nil)
+	p := Join(clock)
+	return s, events, p, func() {
+		p.Leave()
+		s.Close()
+		clock.Stop()
+	}
+}
+
+// Returns the faults the cars detected and saw clear, as "fault@floor" and "fault-cleared@floor", in order.
+func faultEvents(events *eventRecorder) []string {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	var faults []string
+	for _, ev := range events.events {
+		if ev.Kind == EventFault || ev.Kind == EventFaultCleared {
+			faults = append(faults, fmt.Sprintf("%s %s@%s", ev.Kind, ev.Fault, ev.Floor))
+		}
+	}
+	return faults
+}
+
+func injectFault(t *testing.T, s *System, f Fault) {
+	t.Helper()
+	if err := s.InjectFault(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A car which stops between floors is found stuck by its watchdog, and hands back its hall calls. Once the drive
+// runs again, the fault clears, and the car carries on.
+func TestStuckCarDetected(t *testing.T) {
+	s, events, p, close := faultSystem(8, 2)
+	defer close()
+
+	dropoff, pickup := doneChan(p), doneChan(p)
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 6, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendPickup(s, Pickup{Floor: 4, Dir: DOWN, Done: pickup}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(1500 * time.Millisecond)
+	injectFault(t, s, Fault{Kind: FaultStuck, Car: 0})
+	awaitArrival(t, p, pickup, 1, "4 DOWN")
+	if faults := s.Status()[0].Faults; !reflect.DeepEqual(faults, []FaultKind{FaultStuck}) {
+		t.Errorf("Elevator-0 has faults %v, want [stuck]", faults)
+	}
+
+	injectFault(t, s, Fault{Kind: FaultStuck, Car: 0, Clear: true})
+	awaitArrival(t, p, dropoff, 0, "to 6")
+	if got, want := faultEvents(events), []string{"fault stuck@0", "fault-cleared stuck@1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %v, want %v", got, want)
+	}
+	if stats := s.Faults(); stats != (FaultStats{Injected: 1, Detected: 1, Cleared: 1}) {
+		t.Errorf("got %+v, want one fault injected, detected and cleared", stats)
+	}
+}
+
+// A slow car is detected from its time between floors, but still takes hall calls.
+func TestSlowCarDetected(t *testing.T) {
+	s, events, p, close := faultSystem(8, 2)
+	defer close()
+
+	injectFault(t, s, Fault{Kind: FaultSlow, Car: 0})
+	dropoff := doneChan(p)
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 6, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, dropoff, 0, "to 6")
+	if got, want := faultEvents(events), []string{"fault slow@1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %v, want %v", got, want)
+	}
+	if err := SendPickup(s, Pickup{Floor: 2, Dir: UP, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{2, UP}); got != 0 {
+		t.Errorf("2 UP went to Elevator-%d, want Elevator-0, though slow", got)
+	}
+}
+
+// A car which fails to stop at its destination stops at the floor beyond, and comes back. It hands back the pickup
+// it missed, which the System gives to the car which can make it soonest.
+func TestMissedFloor(t *testing.T) {
+	s, events, p, close := faultSystem(8, 2)
+	defer close()
+
+	injectFault(t, s, Fault{Kind: FaultMissedFloor, Car: 0})
+	dropoff, pickup := doneChan(p), doneChan(p)
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 3, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Done: pickup}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, dropoff, 0, "to 3")
+	awaitArrival(t, p, pickup, 0, "3 UP")
+
+	var stops []Floor
+	for _, ev := range events.of(EventStop) {
+		stops = append(stops, ev.Floor)
+	}
+	if want := []Floor{4, 3}; !reflect.DeepEqual(stops, want) {
+		t.Errorf("Elevator-0 stopped at %v, want %v", stops, want)
+	}
+	if got, want := faultEvents(events), []string{"fault missed-floor@3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %v, want %v", got, want)
+	}
+	if faults := s.Status()[0].Faults; len(faults) != 0 {
+		t.Errorf("Elevator-0 has faults %v: a missed floor lasts for one stop", faults)
+	}
+}
+
+// Doors which fail to close DoorJamAttempts times are jammed: the car hands back its hall calls, and stays put
+// until they clear.
+func TestDoorJamDetected(t *testing.T) {
+	s, events, p, close := faultSystem(8, 2)
+	defer close()
+
+	injectFault(t, s, Fault{Kind: FaultDoorJam, Car: 0})
+	pickup := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 0, Dir: UP, Done: pickup}); err != nil {
+		t.Fatal(err)
+	}
+	car := awaitArrival(t, p, pickup, 0, "0 UP").Conveyor
+	dropoff := doneChan(p)
+	if err := SendDropoff(car, Dropoff{Floor: 5, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+	if got, want := faultEvents(events), []string{"fault door-jam@0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %v, want %v", got, want)
+	}
+	second := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 0, Dir: UP, Done: second}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, second, 1, "0 UP again")
+
+	injectFault(t, s, Fault{Kind: FaultDoorJam, Car: 0, Clear: true})
+	awaitArrival(t, p, dropoff, 0, "to 5")
+	if got, want := faultEvents(events), []string{"fault door-jam@0", "fault-cleared door-jam@0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got faults %v, want %v", got, want)
+	}
+}
+
+// A fault is journaled once the car has it. After Close, InjectFault returns ErrClosed, and journals nothing.
+func TestInjectFaultClosed(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	var buf bytes.Buffer
+	s := NewSystem(5, DefaultCarSpecs(2), firstDispatcher{}, clock, nil, NewJournal(&buf, clock))
+
+	if err := s.InjectFault(Fault{Kind: FaultDoorJam, Car: 1}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if err := s.InjectFault(Fault{Kind: FaultStuck, Car: 0}); err != ErrClosed {
+		t.Errorf("after Close, InjectFault returned %v, want ErrClosed", err)
+	}
+	if got := strings.Count(buf.String(), `"kind":"fault"`); got != 1 {
+		t.Errorf("the journal has %d faults, want 1:\n%s", got, buf.String())
+	}
+	if stats := s.Faults(); stats.Injected != 1 {
+		t.Errorf("%d faults injected, want 1", stats.Injected)
+	}
+}
@@ -0,0 +1,120 @@
+// Package faults scripts the Faults injected into a System (see lift.Fault), to see how its controller copes:
+// from a JSON file, or at random.
+package faults
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+// A Fault injected At some time after the start of a run, and cleared For later (unless For is zero: then it
+// lasts, except a FaultMissedFloor, which lasts one stop anyway). Beware: passengers in a car which is stuck,
+// or whose doors are jammed, for good, never arrive.
+type Scripted struct {
+	At  lift.Duration `json:"at"`
+	For lift.Duration `json:"for,omitempty"`
+	lift.Fault
+}
+
+// A Script is sorted by At.
+type Script []Scripted
+
+// Reads a Script from a JSON file: an array of Scripted faults, e.g.
+//
+//	[{"at": "10m", "car": 1, "fault": "stuck", "for": "2m"}, {"at": "20m", "car": 0, "fault": "slow", "factor": 0.5}]
+func Load(path string) (Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, f := range script {
+		if f.At < 0 || f.For < 0 {
+			return nil, fmt.Errorf("%s: fault %d: at and for must not be negative", path, i)
+		}
+		if err := f.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: fault %d: %v", path, i, err)
+		}
+	}
+	sort.SliceStable(script, func(i, k int) bool { return script[i].At < script[k].At })
+	return script, nil
+}
+
+// How long each kind of fault lasts in a Random Script: from the first duration to the second.
+var randomDurations = map[lift.FaultKind][2]time.Duration{
+	lift.FaultStuck:        {30 * time.Second, 3 * time.Minute},
+	lift.FaultSlow:         {2 * time.Minute, 10 * time.Minute},
+	lift.FaultMissedFloor:  {0, 0},
+	lift.FaultDoorJam:      {30 * time.Second, 3 * time.Minute},
+	lift.FaultOutOfService: {2 * time.Minute, 10 * time.Minute},
+}
+
+// Returns a Script of random faults in the first span of a run: each car has rate faults per hour (a Poisson
+// process), of any kind, each lasting a random while. The same seed gives the same Script.
+func Random(cars int, rate float64, span time.Duration, seed int64) Script {
+	rnd := rand.New(rand.NewSource(seed))
+	var script Script
+	if rate <= 0 {
+		return script
+	}
+	mean := float64(time.Hour) / rate
+	for car := 0; car < cars; car++ {
+		for at := time.Duration(0); ; {
+			at += time.Duration(rnd.ExpFloat64() * mean)
+			if at >= span {
+				break
+			}
+			kind := lift.FaultKinds[rnd.Intn(len(lift.FaultKinds))]
+			d := randomDurations[kind]
+			lasts := d[0] + time.Duration(rnd.Int63n(int64(d[1]-d[0])+1))
+			script = append(script, Scripted{At: lift.Duration(at), For: lift.Duration(lasts),
+				Fault: lift.Fault{Kind: kind, Car: car}})
+		}
+	}
+	sort.SliceStable(script, func(i, k int) bool { return script[i].At < script[k].At })
+	return script
+}
+
+// Injects the faults of the Script into the System, each At its time (relative to now), and clears them For later,
+// until they are all done, or stop is closed. part is its Participant of the clock (see lift.Join).
+func (script Script) Run(s *lift.System, clock lift.Clock, part *lift.Participant, stop <-chan struct{}) {
+	type injection struct {
+		at    time.Duration
+		fault lift.Fault
+	}
+	var injections []injection
+	for _, f := range script {
+		injections = append(injections, injection{time.Duration(f.At), f.Fault})
+		if f.For > 0 && f.Kind != lift.FaultMissedFloor {
+			end := f.Fault
+			end.Clear = true
+			injections = append(injections, injection{time.Duration(f.At + f.For), end})
+		}
+	}
+	sort.SliceStable(injections, func(i, k int) bool { return injections[i].at < injections[k].at })
+
+	start := clock.Now()
+	for _, inj := range injections {
+		if wait := start.Add(inj.at).Sub(clock.Now()); wait > 0 {
+			timer := clock.After(wait)
+			part.Idle(timer)
+			select {
+			case <-timer:
+			case <-stop:
+				return
+			}
+		}
+		if err := s.InjectFault(inj.fault); err != nil {
+			log.Printf("Faults: cannot inject %v: %v\n", inj.fault, err)
+		}
+	}
+}
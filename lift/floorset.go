@@ -2,64 +2,86 @@ package lift
 
 import (
 	"fmt"
+	"math/bits"
 )
 
-// Maintains the on/off state of a arr of ints.
-// Answers lowest() and highest() -- FUTURE: efficiently. Tree?
+// Maintains the on/off state of a set of floors, as a bitset: bit f%64 of words[f/64] is floor f.
+// A summary bitset (one bit per non-zero word) makes it a 64-ary tree of depth two, so nearest(),
+// lowest() and highest() scan at most a few words, even for thousands of floors.
 type FloorSet struct {
-	arr      []bool
+	words    []uint64
+	summary  []uint64 // Bit w%64 of summary[w/64] is set iff words[w] != 0.
 	maxFloor Floor
+	n        int // How many floors are set.
 }
 
+const wordBits = 64
+
 func newFloorSet(count int) *FloorSet {
-	return &FloorSet{make([]bool, count), Floor(count - 1)}
+	numWords := (count + wordBits - 1) / wordBits
+	return &FloorSet{words: make([]uint64, numWords), summary: make([]uint64, (numWords+wordBits-1)/wordBits),
+		maxFloor: Floor(count - 1)}
+}
+
+func (fs *FloorSet) clone() *FloorSet {
+	c := &FloorSet{words: make([]uint64, len(fs.words)), summary: make([]uint64, len(fs.summary)), maxFloor: fs.maxFloor, n: fs.n}
+	copy(c.words, fs.words)
+	copy(c.summary, fs.summary)
+	return c
+}
+
+func (fs *FloorSet) isSet(floor Floor) bool {
+	return fs.words[floor/wordBits]&(1<<(uint(floor)%wordBits)) != 0
 }
 
 func (fs *FloorSet) set(floor Floor) bool {
-	prev := fs.arr[floor]
-	fs.arr[floor] = true
+	prev := fs.isSet(floor)
+	if !prev {
+		w := int(floor) / wordBits
+		fs.words[w] |= 1 << (uint(floor) % wordBits)
+		fs.summary[w/wordBits] |= 1 << (uint(w) % wordBits)
+		fs.n++
+	}
 	return prev
 }
 func (fs *FloorSet) clear(floor Floor) bool {
-	prev := fs.arr[floor]
-	fs.arr[floor] = false
+	prev := fs.isSet(floor)
+	if prev {
+		w := int(floor) / wordBits
+		fs.words[w] &^= 1 << (uint(floor) % wordBits)
+		if fs.words[w] == 0 {
+			fs.summary[w/wordBits] &^= 1 << (uint(w) % wordBits)
+		}
+		fs.n--
+	}
 	return prev
 }
 
+// Return the number of floors which are set.
+func (fs *FloorSet) count() int { return fs.n }
+
+// Returns the floors which are set, from the bottom.
+func (fs *FloorSet) floors() []Floor {
+	floors := make([]Floor, 0, fs.n)
+	for f, ok := fs.lowest(); ok; f, ok = fs.nearest(f, UP) {
+		floors = append(floors, f)
+	}
+	return floors
+}
+
 // Return nearest enabled in direction from floor; if none found, return the argument floor.
 func (fs *FloorSet) nearest(cur Floor, dir Direction) (Floor, bool) {
-	if dir != UP && dir != DOWN {
-		panic(fmt.Sprintf("Invalid direction for FloorSet.nearest(): %d", dir))
-	}
-	maxFloor := fs.maxFloor // All floorsets must have equal length
-	for f := cur.next(dir); f >= 0 && f <= maxFloor; f = f.next(dir) {
-		if fs.arr[f] {
-			return f, true
-		}
-	}
-	return InvalidFloor, false
+	return floorSetUnion{fs}.nearest(cur, dir)
 }
 
 // Find the next Floor (in the direction), among the specified FloorSets.
 // If none, return cur.
-// Same code as FloorSet.nearest
 // All floorsets must have equal length!
 func nearestInFloorSets(cur Floor, dir Direction, floorSets ...*FloorSet) (Floor, bool) {
-	if dir != UP && dir != DOWN {
-		panic(fmt.Sprintf("Invalid direction for nearestInFloorSets: %d", dir))
-	}
 	if len(floorSets) == 0 {
 		return InvalidFloor, false
 	}
-	maxFloor := floorSets[0].maxFloor // All floorsets must have equal length
-	for f := cur.next(dir); f >= 0 && f <= maxFloor; f = f.next(dir) {
-		for _, fs := range floorSets {
-			if fs.arr[f] {
-				return f, true
-			}
-		}
-	}
-	return InvalidFloor, false
+	return floorSetUnion(floorSets).nearest(cur, dir)
 }
 
 // Return the furthest Floor (including the specified <floor>) in the direction.
@@ -84,29 +106,93 @@ func (fs *FloorSet) furthest(floor Floor, dir Direction) (Floor, bool) {
 
 const InvalidFloor = -1
 
-//
-//// Return the next stop above floor, else floor.
-//func (fs *FloorSet) higher(floor Floor) Floor {
-//	for i := floor + 1; i < len(fs.arr); i++ {
-//		if fs.arr[i] {
-//			return i
-//		}
-//	}
-//	return floor
-//}
-func (fs *FloorSet) lowest() (Floor, bool) {
-	for i := Floor(0); i <= fs.maxFloor; i++ {
-		if fs.arr[i] {
-			return i, true
+func (fs *FloorSet) lowest() (Floor, bool)  { return floorSetUnion{fs}.atOrAbove(0) }
+func (fs *FloorSet) highest() (Floor, bool) { return floorSetUnion{fs}.atOrBelow(fs.maxFloor) }
+
+// A view of the union of FloorSets (of equal length), without copying them.
+type floorSetUnion []*FloorSet
+
+func (u floorSetUnion) word(i int) uint64 {
+	w := uint64(0)
+	for _, fs := range u {
+		w |= fs.words[i]
+	}
+	return w
+}
+
+func (u floorSetUnion) summaryWord(i int) uint64 {
+	w := uint64(0)
+	for _, fs := range u {
+		w |= fs.summary[i]
+	}
+	return w
+}
+
+func (u floorSetUnion) nearest(cur Floor, dir Direction) (Floor, bool) {
+	switch dir {
+	case UP:
+		return u.atOrAbove(cur + 1)
+	case DOWN:
+		return u.atOrBelow(cur - 1)
+	default:
+		panic(fmt.Sprintf("Invalid direction for nearest: %d", dir))
+	}
+}
+
+// Returns the lowest floor set at or above floor.
+func (u floorSetUnion) atOrAbove(floor Floor) (Floor, bool) {
+	if floor < 0 {
+		floor = 0
+	}
+	if floor > u[0].maxFloor {
+		return InvalidFloor, false
+	}
+	w := int(floor) / wordBits
+	if b := u.word(w) & (^uint64(0) << (uint(floor) % wordBits)); b != 0 {
+		return Floor(w*wordBits + bits.TrailingZeros64(b)), true
+	}
+	// Find the next non-zero word in the summary.
+	w++
+	if w >= len(u[0].words) {
+		return InvalidFloor, false
+	}
+	s := w / wordBits
+	b := u.summaryWord(s) & (^uint64(0) << (uint(w) % wordBits))
+	for b == 0 {
+		if s++; s >= len(u[0].summary) {
+			return InvalidFloor, false
 		}
+		b = u.summaryWord(s)
 	}
-	return InvalidFloor, false
+	w = s*wordBits + bits.TrailingZeros64(b)
+	return Floor(w*wordBits + bits.TrailingZeros64(u.word(w))), true
 }
-func (fs *FloorSet) highest() (Floor, bool) {
-	for i := fs.maxFloor; i >= 0; i-- {
-		if fs.arr[i] {
-			return i, true
+
+// Returns the highest floor set at or below floor.
+func (u floorSetUnion) atOrBelow(floor Floor) (Floor, bool) {
+	if floor > u[0].maxFloor {
+		floor = u[0].maxFloor
+	}
+	if floor < 0 {
+		return InvalidFloor, false
+	}
+	w := int(floor) / wordBits
+	if b := u.word(w) & (^uint64(0) >> (wordBits - 1 - uint(floor)%wordBits)); b != 0 {
+		return Floor(w*wordBits + wordBits - 1 - bits.LeadingZeros64(b)), true
+	}
+	// Find the previous non-zero word in the summary.
+	w--
+	if w < 0 {
+		return InvalidFloor, false
+	}
+	s := w / wordBits
+	b := u.summaryWord(s) & (^uint64(0) >> (wordBits - 1 - uint(w)%wordBits))
+	for b == 0 {
+		if s--; s < 0 {
+			return InvalidFloor, false
 		}
+		b = u.summaryWord(s)
 	}
-	return InvalidFloor, false
+	w = s*wordBits + wordBits - 1 - bits.LeadingZeros64(b)
+	return Floor(w*wordBits + wordBits - 1 - bits.LeadingZeros64(u.word(w))), true
 }
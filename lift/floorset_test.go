@@ -0,0 +1,138 @@
+package lift
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The previous FloorSet, which scans a []bool. Kept to check and benchmark FloorSet against.
+type scanFloorSet struct {
+	arr      []bool
+	maxFloor Floor
+}
+
+func (fs *scanFloorSet) nearestIn(cur Floor, dir Direction, others ...*scanFloorSet) (Floor, bool) {
+	for f := cur.next(dir); f >= 0 && f <= fs.maxFloor; f = f.next(dir) {
+		if fs.arr[f] {
+			return f, true
+		}
+		for _, o := range others {
+			if o.arr[f] {
+				return f, true
+			}
+		}
+	}
+	return InvalidFloor, false
+}
+
+func (fs *scanFloorSet) lowest() (Floor, bool) {
+	for i := Floor(0); i <= fs.maxFloor; i++ {
+		if fs.arr[i] {
+			return i, true
+		}
+	}
+	return InvalidFloor, false
+}
+
+func (fs *scanFloorSet) highest() (Floor, bool) {
+	for i := fs.maxFloor; i >= 0; i-- {
+		if fs.arr[i] {
+			return i, true
+		}
+	}
+	return InvalidFloor, false
+}
+
+// Returns three FloorSets (like an elevator's dropoffs and pickups) with a few random floors set, in both forms.
+func randomFloorSets(rnd *rand.Rand, numFloors, numSet int) ([]*FloorSet, []*scanFloorSet) {
+	var sets []*FloorSet
+	var scans []*scanFloorSet
+	for i := 0; i < 3; i++ {
+		fs, scan := newFloorSet(numFloors), &scanFloorSet{make([]bool, numFloors), Floor(numFloors - 1)}
+		for j := 0; j < numSet; j++ {
+			f := Floor(rnd.Intn(numFloors))
+			fs.set(f)
+			scan.arr[f] = true
+		}
+		sets, scans = append(sets, fs), append(scans, scan)
+	}
+	return sets, scans
+}
+
+func TestFloorSetMatchesScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, numFloors := range []int{1, 2, 63, 64, 65, 200, 4096, 5000} {
+		for _, numSet := range []int{0, 1, 3, 50} {
+			sets, scans := randomFloorSets(rnd, numFloors, numSet)
+			for cur := Floor(0); int(cur) < numFloors; cur++ {
+				for _, dir := range []Direction{UP, DOWN} {
+					got, gotOk := nearestInFloorSets(cur, dir, sets...)
+					want, wantOk := scans[0].nearestIn(cur, dir, scans[1:]...)
+					if got != want || gotOk != wantOk {
+						t.Fatalf("%d floors, %d set: nearest %s from %s is %d, want %d", numFloors, numSet, dir, cur, got, want)
+					}
+				}
+			}
+			got, _ := sets[0].lowest()
+			if want, _ := scans[0].lowest(); got != want {
+				t.Errorf("%d floors, %d set: lowest is %d, want %d", numFloors, numSet, got, want)
+			}
+			got, _ = sets[0].highest()
+			if want, _ := scans[0].highest(); got != want {
+				t.Errorf("%d floors, %d set: highest is %d, want %d", numFloors, numSet, got, want)
+			}
+		}
+	}
+}
+
+// The heights of the benchmarked towers, each with a few requests, as in calculateNextStop.
+var benchFloors = []int{20, 200, 2000}
+
+// Runs f for each of benchFloors, on random sets.
+func benchFloorSets(b *testing.B, f func(b *testing.B, sets []*FloorSet, scans []*scanFloorSet)) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, numFloors := range benchFloors {
+		sets, scans := randomFloorSets(rnd, numFloors, 2)
+		b.Run(fmt.Sprint(numFloors), func(b *testing.B) { f(b, sets, scans) })
+	}
+}
+
+// Nearest in three FloorSets, from every floor, both ways.
+func BenchmarkNearestFloorSet(b *testing.B) {
+	benchFloorSets(b, func(b *testing.B, sets []*FloorSet, _ []*scanFloorSet) {
+		for i := 0; i < b.N; i++ {
+			for cur := Floor(0); cur <= sets[0].maxFloor; cur++ {
+				nearestInFloorSets(cur, UP, sets...)
+				nearestInFloorSets(cur, DOWN, sets...)
+			}
+		}
+	})
+}
+
+func BenchmarkNearestScan(b *testing.B) {
+	benchFloorSets(b, func(b *testing.B, _ []*FloorSet, scans []*scanFloorSet) {
+		for i := 0; i < b.N; i++ {
+			for cur := Floor(0); cur <= scans[0].maxFloor; cur++ {
+				scans[0].nearestIn(cur, UP, scans[1:]...)
+				scans[0].nearestIn(cur, DOWN, scans[1:]...)
+			}
+		}
+	})
+}
+
+func BenchmarkLowestFloorSet(b *testing.B) {
+	benchFloorSets(b, func(b *testing.B, sets []*FloorSet, _ []*scanFloorSet) {
+		for i := 0; i < b.N; i++ {
+			sets[0].lowest()
+		}
+	})
+}
+
+func BenchmarkLowestScan(b *testing.B) {
+	benchFloorSets(b, func(b *testing.B, _ []*FloorSet, scans []*scanFloorSet) {
+		for i := 0; i < b.N; i++ {
+			scans[0].lowest()
+		}
+	})
+}
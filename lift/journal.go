@@ -0,0 +1,287 @@
+package lift
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Journal records every request a System receives, before it is handled (a write-ahead log): each Pickup the
+// System receives, each Dropoff a car receives, each Fault, mode change and move, and each floor a car's drive
+// passes or stops at, with the time of the System's Clock. The first record says how the System started. See
+// Replay, which rebuilds the System from it.
+type Journal struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	sync    func() error // Flushes the writer to storage, if it can be.
+	clock   Clock
+	seq     int64
+	err     error
+	observe func(JournalRecord) // Receives the records instead of enc. For Replay.
+}
+
+type JournalKind string
+
+const (
+	JournalStart   JournalKind = "start"   // The Building and seed of the System, and the Snapshot it resumed from, if any.
+	JournalPickup  JournalKind = "pickup"  // A Pickup the System received.
+	JournalDropoff JournalKind = "dropoff" // A Dropoff a car received.
+	JournalDrive   JournalKind = "drive"   // A car's drive passed (or stopped at) a floor.
+	JournalFault   JournalKind = "fault"   // A Fault injected into a car (see System.InjectFault).
+	JournalMode    JournalKind = "mode"    // A car's CarMode was set (see System.SetCarMode).
+	JournalMove    JournalKind = "move"    // A car in ModeMaintenance was told to move to Floor (see System.MoveCar).
+)
+
+// One line of a Journal. Which fields are set depends on the Kind.
+type JournalRecord struct {
+	Seq      int64       `json:"seq"`
+	Time     time.Time   `json:"time"`
+	Kind     JournalKind `json:"kind"`
+	Car      int         `json:"car"` // -1 for a Pickup.
+	Floor    Floor       `json:"floor"`
+	Dir      Direction   `json:"dir,omitempty"`
+	Dests    []Floor     `json:"dests,omitempty"`
+	Persons  int         `json:"persons,omitempty"`
+	Kg       int         `json:"kg,omitempty"`
+	Listener string      `json:"listener,omitempty"`
+	Stopping bool        `json:"stopping,omitempty"`
+	Building *Building   `json:"building,omitempty"`
+	Seed     int64       `json:"seed,omitempty"`
+	Snapshot *Snapshot   `json:"snapshot,omitempty"`
+	Fault    *Fault      `json:"fault,omitempty"`
+	Mode     CarMode     `json:"mode,omitempty"`
+}
+
+// Returns a Journal which writes each record to w as one line of JSON, at once. If w has a Sync method (as an
+// *os.File does), the Journal calls it after each record but a drive's: a crash of the process or the machine
+// loses no request the System received, only the drives since. Otherwise a crash of the machine may lose whatever
+// w had not yet passed on. The clock must be the System's.
+func NewJournal(w io.Writer, clock Clock) *Journal {
+	j := &Journal{enc: json.NewEncoder(w), clock: clock}
+	if s, ok := w.(interface{ Sync() error }); ok {
+		j.sync = s.Sync
+	}
+	return j
+}
+
+// Records how the System starts: it is created for the Building with the seed (see Building.NewSystem), or resumed
+// from the Snapshot, if it is not nil. Call it once, before creating the System.
+func (j *Journal) Start(building *Building, seed int64, snap *Snapshot) {
+	j.record(JournalRecord{Kind: JournalStart, Car: -1, Building: building, Seed: seed, Snapshot: snap})
+}
+
+// Returns the first error writing a record, if any. Records after it are dropped.
+func (j *Journal) Err() error { return j.err }
+
+// A nil *Journal drops the records.
+func (j *Journal) record(rec JournalRecord) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	rec.Seq, rec.Time = j.seq, j.clock.Now()
+	if j.observe != nil {
+		j.observe(rec)
+	} else if j.err == nil {
+		j.err = j.enc.Encode(rec)
+		if j.err == nil && j.sync != nil && rec.Kind != JournalDrive { // A drive can be had again by Replay.
+			j.err = j.sync()
+		}
+	}
+}
+
+func (j *Journal) pickup(pickup Pickup) {
+	j.record(JournalRecord{Kind: JournalPickup, Car: -1, Floor: pickup.Floor, Dir: pickup.Dir, Dests: pickup.Dests,
+		Persons: pickup.Persons, Listener: pickup.Listener})
+}
+
+func (j *Journal) dropoff(car int, dropoff Dropoff) {
+	j.record(JournalRecord{Kind: JournalDropoff, Car: car, Floor: dropoff.Floor, Persons: dropoff.Persons,
+		Kg: dropoff.Kg, Listener: dropoff.Listener})
+}
+
+func (j *Journal) drive(car int, n DriverStopNotification) {
+	j.record(JournalRecord{Kind: JournalDrive, Car: car, Floor: n.floor, Stopping: n.stopping})
+}
+
+func (j *Journal) fault(f Fault) {
+	j.record(JournalRecord{Kind: JournalFault, Car: f.Car, Fault: &f})
+}
+
+func (j *Journal) mode(car int, mode CarMode) {
+	j.record(JournalRecord{Kind: JournalMode, Car: car, Mode: mode})
+}
+
+func (j *Journal) move(car int, floor Floor) {
+	j.record(JournalRecord{Kind: JournalMove, Car: car, Floor: floor})
+}
+
+// The outcome of Replay.
+type Replayed struct {
+	System   *System
+	Building *Building
+	Start    time.Time // The time of the start record, by the journal's clock.
+	Until    time.Time // The time (by the journal's clock) up to which it was replayed.
+	Requests int       // How many Pickups, Dropoffs, Faults, mode changes and moves were replayed.
+	Drives   int       // How many drive records were checked.
+	// The first drive record (in time order) which the replay did not reproduce, and what it did instead (nil if it
+	// did nothing more). If Diverged is nil but Replayed is not, the replay drove past the end of the journal's drives.
+	// Both are nil if all matched.
+	Diverged *JournalRecord
+	Replayed *JournalRecord
+}
+
+// Rebuilds a System from the journal read from r. It is created as the start record says, and sent each Pickup,
+// Dropoff, Fault, mode change and move at the time the journal gives it, relative to the start, until that much
+// time (or, if until is zero, the whole journal) has passed. The clock is then paused, and the drive records are
+// checked against the replay: the System should have passed the same floors at the same times, give or take
+// tolerance. A journal of a System on the VirtualClock replays exactly; one on the RealClock only as closely as
+// its goroutines kept time.
+// The System sends its Events to events, unless it is nil. Arrivals are received, and dropped.
+func Replay(r io.Reader, clock *VirtualClock, until, tolerance time.Duration, events EventSink) (*Replayed, error) {
+	dec := json.NewDecoder(r)
+	var start JournalRecord
+	if err := dec.Decode(&start); err != nil {
+		return nil, fmt.Errorf("journal: %v", err)
+	}
+	if start.Kind != JournalStart || start.Building == nil {
+		return nil, fmt.Errorf("journal: the first record is %q, not a start record", start.Kind)
+	}
+	var replayed []JournalRecord
+	journal := &Journal{clock: clock, observe: func(rec JournalRecord) {
+		if rec.Kind == JournalDrive {
+			replayed = append(replayed, rec)
+		}
+	}}
+	part := Join(clock) // We send the requests in time: the clock must wait for us.
+	defer part.Leave()
+	shift := clock.Now().Sub(start.Time)
+	var s *System
+	var err error
+	if start.Snapshot != nil {
+		s, err = start.Building.NewSystemFromSnapshot(start.Snapshot, clock, start.Seed, events, journal, nil)
+	} else {
+		s, err = start.Building.NewSystem(clock, start.Seed, events, journal)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Replayed{System: s, Building: start.Building, Start: start.Time, Until: start.Time.Add(until)}
+	var journaled []JournalRecord
+	lastSeq := start.Seq
+	for {
+		var rec JournalRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("journal: after record %d: %v", lastSeq, err)
+		}
+		lastSeq = rec.Seq
+		if until > 0 && rec.Time.After(result.Until) {
+			break
+		}
+		if until == 0 {
+			result.Until = rec.Time
+		}
+		if wait := rec.Time.Add(shift).Sub(clock.Now()); wait > 0 {
+			part.Sleep(wait)
+		}
+		switch rec.Kind {
+		case JournalPickup:
+			if err := SendPickup(s, Pickup{Floor: rec.Floor, Dir: rec.Dir, Dests: rec.Dests, Persons: rec.Persons,
+				Listener: rec.Listener, Done: make(chan Arrival, 1)}); err != nil {
+				return result, err
+			}
+			result.Requests++
+		case JournalDropoff:
+			if rec.Car < 0 || rec.Car >= len(s.Conveyors()) {
+				return result, fmt.Errorf("journal: record %d: no car %d", rec.Seq, rec.Car)
+			}
+			if err := SendDropoff(s.Conveyors()[rec.Car], Dropoff{Floor: rec.Floor, Persons: rec.Persons, Kg: rec.Kg,
+				Listener: rec.Listener, Done: make(chan Arrival, 1)}); err != nil {
+				return result, err
+			}
+			result.Requests++
+		case JournalFault:
+			if rec.Fault == nil {
+				return result, fmt.Errorf("journal: record %d: no fault", rec.Seq)
+			}
+			if err := s.InjectFault(*rec.Fault); err != nil {
+				return result, fmt.Errorf("journal: record %d: %v", rec.Seq, err)
+			}
+			result.Requests++
+		case JournalMode:
+			if err := s.SetCarMode(rec.Car, rec.Mode); err != nil {
+				return result, fmt.Errorf("journal: record %d: %v", rec.Seq, err)
+			}
+			result.Requests++
+		case JournalMove:
+			if err := s.MoveCar(rec.Car, rec.Floor); err != nil {
+				log.Printf("Replay: record %d: %v\n", rec.Seq, err) // As it was refused at the time, no doubt.
+			}
+			result.Requests++
+		case JournalDrive:
+			journaled = append(journaled, rec)
+		default:
+			log.Printf("Replay: skipping record %d of kind %q\n", rec.Seq, rec.Kind)
+		}
+	}
+	if wait := result.Until.Add(shift).Sub(clock.Now()); wait > 0 {
+		part.Sleep(wait)
+	}
+	clock.Pause()
+
+	// Drives at the same moment may be recorded in either order, so we compare them in time and car order.
+	// Those of the last moment (give or take tolerance) may not all have happened yet.
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.observe = func(JournalRecord) {} // The System may run on: we have what we need.
+	for i := range replayed {
+		replayed[i].Time = replayed[i].Time.Add(-shift)
+	}
+	sortDrives(journaled)
+	sortDrives(replayed)
+	cutoff := result.Until.Add(-tolerance)
+	for i, want := range journaled {
+		if !want.Time.Before(cutoff) {
+			break
+		}
+		result.Drives++
+		if i >= len(replayed) {
+			result.Diverged = &journaled[i]
+			return result, nil
+		}
+		if got := replayed[i]; absDuration(got.Time.Sub(want.Time)) > tolerance || got.Car != want.Car ||
+			got.Floor != want.Floor || got.Stopping != want.Stopping {
+			result.Diverged, result.Replayed = &journaled[i], &replayed[i]
+			return result, nil
+		}
+	}
+	if n := result.Drives; n < len(replayed) && replayed[n].Time.Before(cutoff) {
+		result.Replayed = &replayed[n]
+	}
+	return result, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func sortDrives(recs []JournalRecord) {
+	sort.SliceStable(recs, func(i, k int) bool {
+		if !recs[i].Time.Equal(recs[k].Time) {
+			return recs[i].Time.Before(recs[k].Time)
+		}
+		return recs[i].Car < recs[k].Car
+	})
+}
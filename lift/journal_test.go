@@ -0,0 +1,96 @@
+package lift
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Replaying a journal rebuilds the System it was written by: its drives match, and it is in the same state
+// (cars part way through their runs, and calls still waiting) when the replay stops.
+func TestReplayRebuildsState(t *testing.T) {
+	building := NewBuilding(10, 3)
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	var buf bytes.Buffer
+	journal := NewJournal(&buf, clock)
+	journal.Start(building, 1, nil)
+	s, err := building.NewSystem(clock, 1, nil, journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Hall calls a few seconds apart, then a car out of service. We stop the clock while a car is on its way.
+	p := Join(clock)
+	defer p.Leave()
+	for _, call := range []struct {
+		floor, dest Floor
+		dir         Direction
+	}{{0, 7, UP}, {5, 1, DOWN}, {8, 2, DOWN}, {3, 9, UP}} {
+		if err := SendPickup(s, Pickup{Floor: call.floor, Dir: call.dir, Dests: []Floor{call.dest},
+			Done: make(chan Arrival, 1)}); err != nil {
+			t.Fatal(err)
+		}
+		p.Sleep(3 * time.Second)
+	}
+	if err := s.SetCarMode(2, ModeOutOfService); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(4*time.Second + 300*time.Millisecond)
+	clock.Pause()
+	until := clock.Now().Sub(Epoch)
+	want, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Err() != nil {
+		t.Fatal(journal.Err())
+	}
+
+	replayClock := NewVirtualClock(Epoch)
+	defer replayClock.Stop()
+	r, err := Replay(&buf, replayClock, until, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.System.Close()
+	if r.Requests != 5 {
+		t.Errorf("replayed %d requests, want 5", r.Requests)
+	}
+	if r.Drives == 0 || r.Diverged != nil || r.Replayed != nil {
+		t.Errorf("after %d drives, the replay diverged: the journal has %v, the replay %v", r.Drives, r.Diverged, r.Replayed)
+	}
+	got, err := json.Marshal(r.System.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("the replayed System differs:\n got %s\nwant %s", got, want)
+	}
+}
+
+// Counts the Syncs of the writer it wraps.
+type syncCounter struct {
+	bytes.Buffer
+	syncs int
+}
+
+func (w *syncCounter) Sync() error {
+	w.syncs++
+	return nil
+}
+
+// The Journal syncs a writer which can be after each record but a drive's.
+func TestJournalSyncsRequests(t *testing.T) {
+	var w syncCounter
+	journal := NewJournal(&w, RealClock{})
+	journal.Start(NewBuilding(5, 1), 1, nil)
+	journal.pickup(Pickup{Floor: 2, Dir: UP})
+	journal.drive(0, DriverStopNotification{floor: 1})
+	journal.dropoff(0, Dropoff{Floor: 4})
+	if w.syncs != 3 {
+		t.Errorf("the Journal synced %d times, want 3", w.syncs)
+	}
+}
@@ -0,0 +1,76 @@
+package lift
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// How long, once closed, we wait for a client to receive a notification (e.g. that its request was Cancelled),
+// before we drop it. Real time: Close must not hang on a client which has stopped reading.
+const notifyGrace = time.Second
+
+// Tracks the goroutines of a System, Elevator or driver, so that Close can stop them, and wait until they have.
+// Every goroutine must return soon after quit is closed; one which notifies a client's Done channel, within
+// notifyGrace. The zero value is not usable: quit must be made.
+type lifecycle struct {
+	quit  chan struct{} // Closed when the owner is closed.
+	once  sync.Once
+	wg    sync.WaitGroup
+	clock Clock // Each goroutine is a Participant.
+}
+
+// Runs f in a goroutine, which close waits for. It is the Participant p of the clock, which receives requests on
+// inbox, until f returns.
+func (l *lifecycle) goroutine(f func(p *Participant), inbox ...interface{}) {
+	p := Join(l.clock, inbox...)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer p.Leave()
+		f(p)
+	}()
+}
+
+// Closes quit (once), and waits until every goroutine has returned.
+func (l *lifecycle) close() {
+	l.once.Do(func() { close(l.quit) })
+	l.wg.Wait()
+}
+
+// Sends the Arrival to a client's Done channel, without blocking the caller.
+func (l *lifecycle) notify(ch chan<- Arrival, arrival Arrival) {
+	l.goroutine(func(p *Participant) {
+		l.deliver(p, ch, arrival)
+	})
+}
+
+// Sends the Arrival to a client's Done channel, as participant p. Once quit is closed, gives the client notifyGrace
+// to receive, then drops it.
+func (l *lifecycle) deliver(p *Participant, ch chan<- Arrival, arrival Arrival) {
+	if !expect(ch) {
+		p.Idle() // The client takes no part in the simulation: time need not wait for it.
+	}
+	select {
+	case ch <- arrival:
+		return
+	case <-l.quit:
+	}
+	select {
+	case ch <- arrival:
+	case <-time.After(notifyGrace):
+		unexpect(ch)
+		log.Printf("Dropped %s arrival at %s %s on channel %v: nobody received it\n",
+			arrival.Outcome, arrival.Floor, arrival.Dir, ch)
+	}
+}
+
+// Notifies each listener that its request was Cancelled, because conveyor (nil for the System) is closing.
+func (m ArrivalListeners) cancelAll(conveyor Conveyor, life *lifecycle) {
+	for floorDir, listeners := range m {
+		for _, l := range listeners {
+			life.notify(l.ch, Arrival{Floor: floorDir.floor, Dir: floorDir.dir, Conveyor: conveyor, Outcome: Cancelled})
+		}
+		delete(m, floorDir)
+	}
+}
@@ -0,0 +1,125 @@
+package lift
+
+import (
+	"log"
+)
+
+// What an Elevator car may carry. A zero field means no limit of that kind.
+type Capacity struct {
+	Persons int
+	Kg      int
+}
+
+// Used to estimate the weight of passengers when a Dropoff does not say.
+const AveragePassengerKg = 75
+
+var DefaultCapacity = Capacity{Persons: 8, Kg: 630}
+
+// By default, a car which is 80% full stops answering hall calls.
+const DefaultBypassLoad = 0.8
+
+// The passengers aboard a car (or alighting at one floor).
+type load struct {
+	persons int
+	kg      int
+}
+
+func dropoffLoad(dropoff Dropoff) load {
+	l := load{dropoff.Persons, dropoff.Kg}
+	if l.persons <= 0 {
+		l.persons = 1
+	}
+	if l.kg <= 0 {
+		l.kg = l.persons * AveragePassengerKg
+	}
+	return l
+}
+
+func (l load) add(other load) load { return load{l.persons + other.persons, l.kg + other.kg} }
+func (l load) sub(other load) load { return load{l.persons - other.persons, l.kg - other.kg} }
+
+// Returns true if the load exceeds fraction of the capacity, in persons or kg.
+func (l load) exceeds(c Capacity, fraction float64) bool {
+	return (c.Persons > 0 && float64(l.persons) > fraction*float64(c.Persons)) ||
+		(c.Kg > 0 && float64(l.kg) > fraction*float64(c.Kg))
+}
+
+// Returns true if the load is at least fraction of the capacity, in persons or kg.
+func (l load) reaches(c Capacity, fraction float64) bool {
+	return (c.Persons > 0 && float64(l.persons) >= fraction*float64(c.Persons)) ||
+		(c.Kg > 0 && float64(l.kg) >= fraction*float64(c.Kg))
+}
+
+// Returns true if the car is loaded to its bypass threshold: it does not answer hall calls.
+func (e *Elevator) full() bool { return e.load.reaches(e.capacity, e.bypassLoad) }
+
+// Returns true if the passengers would fit in the car, were it empty.
+func (e *Elevator) fits(l load) bool { return !l.exceeds(e.capacity, 1) }
+
+// The load of a group of persons (at least one), at AveragePassengerKg each.
+func groupLoad(persons int) load { return dropoffLoad(Dropoff{Persons: persons}) }
+
+// Passengers boarded with the dropoff. Returns false (and changes nothing) if they do not fit.
+func (e *Elevator) board(dropoff Dropoff) bool {
+	l := e.load.add(dropoffLoad(dropoff))
+	if l.exceeds(e.capacity, 1) {
+		log.Printf("Elevator-%d refused %v: full with %d persons, %d kg\n", e.id, dropoff, e.load.persons, e.load.kg)
+		return false
+	}
+	e.load = l
+	e.alighting[dropoff.Floor] = e.alighting[dropoff.Floor].add(dropoffLoad(dropoff))
+	if e.full() {
+		e.bypassPickups("full")
+	}
+	return true
+}
+
+// Passengers for this floor get out. Returns how many.
+func (e *Elevator) alight() int {
+	l := e.alighting[e.floor]
+	e.alighting[e.floor] = load{}
+	e.load = e.load.sub(l)
+	return l.persons
+}
+
+// Hands back all our pickups to the System, for dispatch to another car. The reason is for the log.
+func (e *Elevator) bypassPickups(reason string) {
+	var returns []Pickup
+	for _, dir := range []Direction{UP, DOWN} {
+		for f := Floor(0); int(f) < e.numFloors; f++ {
+			returns = append(returns, e.takeBackPickups(f, dir)...)
+		}
+	}
+	if len(returns) == 0 {
+		return
+	}
+	log.Printf("Elevator-%d is %s, returning %v\n", e.id, reason, returns)
+	e.returnPickups(returns...)
+}
+
+// Forgets our pickup at the floor, going dir, if we have it. Returns it, to be handed back (see removePickups).
+func (e *Elevator) takeBackPickups(f Floor, dir Direction) []Pickup {
+	if !e.pickups(dir).clear(f) {
+		return nil
+	}
+	return e.waiters.removePickups(FloorDir{f, dir})
+}
+
+// If we are closed first, the passengers waiting for the pickups are told it was Cancelled.
+func (e *Elevator) returnPickups(pickups ...Pickup) {
+	e.life.goroutine(func(part *Participant) {
+		for _, p := range pickups {
+			if !expect(e.chReturns) {
+				part.Idle() // We have no System: time need not wait.
+			}
+			select {
+			case e.chReturns <- p:
+			case <-e.life.quit:
+				unexpect(e.chReturns)
+				if p.Done != nil {
+					e.life.deliver(part, p.Done, Arrival{Floor: p.Floor, Dir: p.Dir, Conveyor: e, Outcome: Cancelled})
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package lift
+
+import (
+	"testing"
+	"time"
+)
+
+// Chooses the first car which does not bypass the pickup, so that tests know which car takes it.
+type firstDispatcher struct{}
+
+func (firstDispatcher) Dispatch(pickup Pickup, estimates []PickupEstimate) Conveyor {
+	return estimates[0].Conveyor
+}
+
+// Returns a System of cars of the capacities (in persons), on a VirtualClock; the test's Participant of the clock,
+// so that time stands still while the test makes its requests; and a func to close them all.
+func capacitySystem(numFloors int, persons ...int) (*System, *Participant, func()) {
+	specs := DefaultCarSpecs(len(persons))
+	for i, p := range persons {
+		specs[i].Capacity = Capacity{Persons: p}
+	}
+	return testSystem(numFloors, specs)
+}
+
+// Returns a System of the cars, as capacitySystem.
+func testSystem(numFloors int, specs []CarSpec) (*System, *Participant, func()) {
+	clock := NewVirtualClock(Epoch)
+	s := NewSystem(numFloors, specs, firstDispatcher{}, clock, nil, nil)
+	p := Join(clock)
+	return s, p, func() {
+		p.Leave()
+		s.Close()
+		clock.Stop()
+	}
+}
+
+// Returns a Done channel for a request, whose Arrival the test awaits: the clock waits for the test to take it.
+func doneChan(p *Participant) chan Arrival {
+	ch := make(chan Arrival, 1)
+	p.Listen(ch)
+	return ch
+}
+
+// Waits (letting time run meanwhile) for the Arrival, and checks that car made it.
+func awaitArrival(t *testing.T, p *Participant, ch <-chan Arrival, car int, what string) Arrival {
+	t.Helper()
+	return awaitOutcome(t, p, ch, Served, car, what)
+}
+
+// Waits (letting time run meanwhile) for the Arrival, and checks its Outcome, and that car (-1 for none) sent it.
+func awaitOutcome(t *testing.T, p *Participant, ch <-chan Arrival, outcome Outcome, car int, what string) Arrival {
+	t.Helper()
+	p.Idle()
+	select {
+	case a := <-ch:
+		got := -1
+		if a.Conveyor != nil {
+			got = a.Conveyor.Id()
+		}
+		if a.Outcome != outcome || got != car {
+			t.Fatalf("%s: got %s from Elevator-%d, want %s from Elevator-%d", what, a.Outcome, got, outcome, car)
+		}
+		return a
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s: no Arrival", what)
+	}
+	return Arrival{}
+}
+
+// Returns the car the System dispatched the hall call at floorDir to, or -1.
+func dispatchedTo(s *System, floorDir FloorDir) int {
+	for _, hall := range s.HallCalls() {
+		if hall.Floor == floorDir.floor && hall.Dir == floorDir.dir {
+			return hall.Car
+		}
+	}
+	return -1
+}
+
+// A car boards passengers while they fit, and refuses those who do not fit now, and those who never could.
+func TestBoardWithinCapacity(t *testing.T) {
+	s, p, close := capacitySystem(6, 4)
+	defer close()
+	car := s.Conveyors()[0]
+
+	three, two, five := doneChan(p), doneChan(p), doneChan(p)
+	if err := SendDropoff(car, Dropoff{Floor: 3, Persons: 3, Done: three}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendDropoff(car, Dropoff{Floor: 4, Persons: 2, Done: two}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendDropoff(car, Dropoff{Floor: 4, Persons: 5, Done: five}); err != nil {
+		t.Fatal(err)
+	}
+	awaitOutcome(t, p, two, Refused, 0, "two more, with three aboard")
+	awaitOutcome(t, p, five, Oversized, 0, "five")
+	awaitArrival(t, p, three, 0, "three to 3")
+
+	// Nor does the System dispatch a group which fits in no car.
+	group := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 2, Dir: UP, Persons: 5, Done: group}); err != nil {
+		t.Fatal(err)
+	}
+	awaitOutcome(t, p, group, Oversized, -1, "five at 2 UP")
+	if n := len(s.HallCalls()); n != 0 {
+		t.Errorf("%d hall calls outstanding, want none", n)
+	}
+}
+
+// A car loaded to its bypass threshold is passed over for hall calls, until its passengers get out.
+func TestFullCarBypassed(t *testing.T) {
+	s, p, close := capacitySystem(6, 5, 5)
+	defer close()
+	car := s.Conveyors()[0]
+
+	four := doneChan(p)
+	if err := SendDropoff(car, Dropoff{Floor: 5, Persons: 4, Done: four}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendPickup(s, Pickup{Floor: 2, Dir: UP, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{2, UP}); got != 1 {
+		t.Errorf("with Elevator-0 full, 2 UP went to Elevator-%d, want Elevator-1", got)
+	}
+	awaitArrival(t, p, four, 0, "four to 5")
+
+	if err := SendPickup(s, Pickup{Floor: 4, Dir: DOWN, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{4, DOWN}); got != 0 {
+		t.Errorf("with Elevator-0 empty, 4 DOWN went to Elevator-%d, want Elevator-0", got)
+	}
+}
+
+// A car which fills up hands back its pickups. The System dispatches them again with their group size, so a car
+// too small for the group does not take it.
+func TestFullCarHandsBackGroup(t *testing.T) {
+	s, p, close := capacitySystem(6, 4, 1, 4)
+	defer close()
+
+	chFour := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 0, Dir: UP, Persons: 4, Done: chFour}); err != nil {
+		t.Fatal(err)
+	}
+	car := awaitArrival(t, p, chFour, 0, "four at 0 UP").Conveyor
+
+	// Elevator-0 takes the pair at 3, then the four board it: it is full.
+	chPair := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Persons: 2, Done: chPair}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 0 {
+		t.Fatalf("3 UP went to Elevator-%d, want Elevator-0", got)
+	}
+	if err := SendDropoff(car, Dropoff{Floor: 5, Persons: 4, Done: make(chan Arrival, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	// Elevator-1 holds one person, so Elevator-2 makes the pickup.
+	awaitArrival(t, p, chPair, 2, "two at 3 UP")
+}
+
+// A group which joins a hall call, and does not fit the car it went to, has it dispatched again.
+func TestLargerGroupJoinsCall(t *testing.T) {
+	s, p, close := capacitySystem(6, 1, 4)
+	defer close()
+
+	chOne, chThree := doneChan(p), doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Persons: 1, Done: chOne}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 0 {
+		t.Fatalf("3 UP went to Elevator-%d, want Elevator-0", got)
+	}
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Persons: 3, Done: chThree}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 1 {
+		t.Fatalf("with three waiting, 3 UP is with Elevator-%d, want Elevator-1", got)
+	}
+	awaitArrival(t, p, chOne, 1, "one at 3 UP")
+	awaitArrival(t, p, chThree, 1, "three at 3 UP")
+}
@@ -1,81 +1,292 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"github.com/delliston/mygo/lift"
+	"github.com/delliston/mygo/lift/faults"
+	"github.com/delliston/mygo/lift/sim"
+	"github.com/delliston/mygo/lift/trace"
+	"github.com/delliston/mygo/lift/traffic"
+	"github.com/delliston/mygo/lift/tui"
+	"io"
 	"log"
 	"math/rand"
-	"sync"
+	"os"
+	"strings"
 	"time"
 )
 
 // This could become a System type
 func main() {
-	NumFloors := 5    // Floors are numbered from 0
-	NumElevators := 2 // TODO: Read these from args
-	NumPassengers := 10
-	s := lift.NewSystem(NumFloors, NumElevators)
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+
+	buildingPath := flag.String("building", "", "JSON building description, see lift/buildings (default: 5 floors, 2 cars)")
+	dispatcherName := flag.String("dispatcher", "", fmt.Sprintf("how hall calls are assigned to elevators: one of %v (default: the building's)", lift.DispatcherNames))
+	policyName := flag.String("policy", "", fmt.Sprintf("how every car chooses its next stop: one of %v (default: each car's)", lift.StopPolicyNames))
+	seed := flag.Int64("seed", 1, "seed for the random dispatcher and passengers")
+	realtime := flag.Bool("realtime", false, "run on the wall clock, instead of a virtual clock which skips idle time")
+	printJourneys := flag.Bool("journeys", false, "print every passenger's journey, besides the summary")
+	trafficName := flag.String("traffic", "", "traffic profile: day, up-peak, down-peak, lunch, interfloor, or a JSON file (default: 10 random passengers)")
+	rate := flag.Float64("rate", 6, "passengers per minute, at the peak of the traffic profile")
+	lobby := flag.Int("lobby", 0, "the floor where incoming traffic starts, and outgoing traffic ends")
+	tracePath := flag.String("trace", "", "replay passengers from a CSV or JSON trace file, see lift/trace")
+	writeTrace := flag.String("write-trace", "", "write the passengers to a CSV trace file, for replay with -trace")
+	maxWait := flag.Duration("max-wait", 0, "serve any hall call which has waited this long first (default: the building's, if any)")
+	showTUI := flag.Bool("tui", false, "draw the building in the terminal as the simulation runs, with keys to pause, step and change speed")
+	speed := flag.Float64("speed", 1, "with -tui, how many times faster than real time the simulation runs (0: as fast as possible)")
+	eventsPath := flag.String("events", "", "write every event (hall and car calls, car movements, doors, arrivals) to this file, as JSON Lines")
+	logPath := flag.String("log", "", "write the log to this file, instead of stderr (with -tui, the log is discarded unless -log is given)")
+	journalPath := flag.String("journal", "", "record every request (and every floor the cars pass) in this file, for -replay")
+	replayPath := flag.String("replay", "", "rebuild the System from a -journal file, instead of simulating passengers, and print its state")
+	until := flag.Duration("until", 0, "with -replay, stop this far into the journal (default: at its end)")
+	tolerance := flag.Duration("tolerance", 0, "with -replay, how far the cars may be off the journal's times, e.g. 50ms for a journal of serve")
+	snapshotPath := flag.String("snapshot", "", "with -replay, write the System's snapshot to this file, e.g. for serve -state")
+	faultsPath := flag.String("faults", "", "inject the faults scripted in this JSON file into the cars, see lift/faults")
+	faultRate := flag.Float64("fault-rate", 0, "inject random faults into the cars: this many per car per hour, over the run")
+	flag.Parse()
+
+	if *showTUI && *realtime {
+		log.Fatal("-tui runs on the virtual clock: drop -realtime")
+	}
+	if *replayPath != "" && (*realtime || *showTUI) {
+		log.Fatal("-replay runs on the virtual clock, without -tui: drop -realtime and -tui")
+	}
+	var logOut io.Writer = os.Stderr
+	if *logPath != "" {
+		logFile, err := os.Create(*logPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		logOut = logFile
+	} else if *showTUI {
+		logOut = io.Discard
+	}
+	log.SetOutput(logOut)
 
-	wgPass := sync.WaitGroup{}
+	var clock lift.Clock = lift.RealClock{}
+	if !*realtime {
+		clock = lift.NewVirtualClock(lift.Epoch)
+		log.SetFlags(0)
+		log.SetOutput(&clockWriter{clock, logOut})
+	}
+	if *replayPath != "" {
+		events, closeEvents := openEvents(*eventsPath)
+		ok := replay(*replayPath, *until, *tolerance, *snapshotPath, clock.(*lift.VirtualClock), events)
+		closeEvents()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	rnd := rand.New(rand.NewSource(*seed))
 
-	for id := 1; id <= NumPassengers; id++ {
-		wgPass.Add(1)
-		p := &Passenger{id, lift.Floor(rand.Intn(NumFloors)), lift.Floor(rand.Intn(NumFloors))}
-		log.Printf("Passenger-%d created with start %s, dest %s\n", id, p.start, p.dest)
+	building := loadBuilding(*buildingPath, *dispatcherName)
+	if *policyName != "" {
+		for i := range building.Cars {
+			building.Cars[i].StopPolicy = *policyName
+		}
+	}
+	if *maxWait > 0 {
+		building.MaxWait = lift.Duration(*maxWait)
+		for i := range building.Cars {
+			building.Cars[i].MaxWait = 0
+		}
+	}
+	NumFloors := building.Floors
+	events, closeEvents := openEvents(*eventsPath)
+	journal, closeJournal := openJournal(*journalPath, clock)
+	journal.Start(building, *seed, nil)
+	s, err := building.NewSystem(clock, *seed, events, journal)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Building %q: %d floors, %d elevators, dispatcher %q\n", building.Name, NumFloors, len(building.CarSpecs()), building.Dispatcher)
+
+	var calls []sim.Call
+	if *tracePath != "" {
+		if calls, err = trace.Load(*tracePath, building.ParseFloor, building.MaxGroup()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Trace %q: %d passengers\n", *tracePath, len(calls))
+	} else if *trafficName == "" {
+		NumPassengers := 10
+		for i := 0; i < NumPassengers; i++ {
+			calls = append(calls, sim.Call{At: time.Duration(i) * 5 * lift.Tick,
+				Start: lift.Floor(rnd.Intn(NumFloors)), Dest: lift.Floor(rnd.Intn(NumFloors))})
+		}
+	} else {
+		var profile traffic.Profile
+		if strings.HasSuffix(*trafficName, ".json") {
+			profile, err = traffic.LoadProfile(*trafficName)
+		} else {
+			profile, err = traffic.NamedProfile(*trafficName, *rate)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *lobby < 0 || *lobby >= NumFloors {
+			log.Fatalf("lobby %d is not in the building", *lobby)
+		}
+		calls = traffic.NewGenerator(NumFloors, lift.Floor(*lobby), *seed).Generate(profile)
+		log.Printf("Traffic %q: %d passengers\n", *trafficName, len(calls))
+	}
+
+	if *writeTrace != "" {
+		out, err := os.Create(*writeTrace)
+		if err == nil {
+			err = trace.WriteCSV(out, calls, building.Label)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var screen *tui.Screen
+	var quit <-chan struct{} // Closed when the user quits: we stop the passengers, and shut down as usual.
+	if *showTUI {
+		vc := clock.(*lift.VirtualClock)
+		vc.SetSpeed(*speed)
+		screen = tui.Start(s, building, vc, os.Stdout)
+		quit = screen.Quit()
+	}
+	stopFaults := make(chan struct{})
+	if script := loadFaults(*faultsPath, *faultRate, len(building.CarSpecs()), calls, *seed); len(script) > 0 {
+		log.Printf("Faults: %d scripted\n", len(script))
+		part := lift.Join(clock) // Before the script starts: the clock must not move on meanwhile.
 		go func() {
-			p.main(s.Pickups())
-			wgPass.Done()
+			defer part.Leave()
+			script.Run(s, clock, part, stopFaults)
 		}()
-		time.Sleep(5 * lift.Tick)
 	}
-	wgPass.Wait() // Waits until all passengers complete. This is a bit random. May exit immediately if first passenger has src=dest.
-	log.Println("All passengers have been serviced")
+	journeys := sim.Run(s, clock, calls, quit)
+	close(stopFaults)
+	select {
+	case <-quit:
+		log.Println("Quit: shutting down")
+	default:
+		log.Println("All passengers have been serviced")
+	}
+	if screen != nil {
+		screen.Close()
+	}
+	s.Close()
+	closeEvents()
+	closeJournal()
+	if vc, ok := clock.(*lift.VirtualClock); ok {
+		vc.Stop()
+	}
+
+	if *printJourneys {
+		journeys.Print(os.Stdout)
+	}
+	journeys.Report().Print(os.Stdout)
+	if aging := s.Aging(); aging.Escalated > 0 || aging.AgedPickups > 0 {
+		fmt.Printf("aging rule: %d hall calls escalated, %d pickups made first\n", aging.Escalated, aging.AgedPickups)
+	}
+	if stats := s.Faults(); stats.Injected > 0 {
+		fmt.Printf("faults: %d injected, %d detected, %d cleared\n", stats.Injected, stats.Detected, stats.Cleared)
+	}
 }
 
-// Passenger is group of people who requests a pickup or dropoff
-// NOT USED.
-type Passenger struct {
-	id    int
-	start lift.Floor // Ick: naming start v. end, origin vs. dest?
-	dest  lift.Floor
+// Returns the faults scripted in the file at path, plus random ones at rate per car per hour, until the last of
+// the calls.
+func loadFaults(path string, rate float64, cars int, calls []sim.Call, seed int64) faults.Script {
+	var script faults.Script
+	if path != "" {
+		var err error
+		if script, err = faults.Load(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if rate > 0 && len(calls) > 0 {
+		script = append(script, faults.Random(cars, rate, calls[len(calls)-1].At, seed)...)
+	}
+	return script
 }
 
-func (p *Passenger) main(chPickupReqs chan<- lift.Pickup) {
-	if p.start == p.dest {
-		fmt.Printf("Passenger-%d skipping elevator: start %s == dest %s\n", p.id, p.start, p.dest)
-		return
+// Returns the Building described by the JSON file at path (or, if path is empty, a small default one).
+// A dispatcher name overrides the building's.
+func loadBuilding(path, dispatcher string) *lift.Building {
+	building := lift.NewBuilding(5, 2) // Floors are numbered from 0
+	if path != "" {
+		var err error
+		if building, err = lift.LoadBuilding(path); err != nil {
+			log.Fatal(err)
+		}
 	}
+	if dispatcher != "" {
+		building.Dispatcher = dispatcher
+	}
+	return building
+}
 
-	// Request pickup and wait.
-	chArrival := make(chan lift.Arrival)
-	dir := p.start.DirectionTo(p.dest)
-	pickup := lift.Pickup{p.start, dir, chArrival}
-	log.Printf("Passenger-%d requesting pickup %s %s\n", p.id, p.start, dir)
-	chPickupReqs <- pickup
-	log.Printf("Passenger-%d waiting for pickup %s %s on channel %v\n", p.id, p.start, dir, chArrival)
+// Returns a sink which writes Events to the file at path, as JSON Lines (or nil, if path is empty), and a function
+// to call once the System is closed.
+func openEvents(path string) (lift.EventSink, func()) {
+	if path == "" {
+		return nil, func() {}
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(out)
+	sink := lift.NewJSONLines(w)
+	return sink, func() {
+		err := sink.Err()
+		if ferr := w.Flush(); err == nil {
+			err = ferr
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
 
-	// Wait for arrival.
-	a := <-chArrival
-	if a.Floor != p.start {
-		panic(fmt.Sprintf("Waiting at %s, but pickup arrival says %s", p.start, a.Floor))
+// Returns a Journal which writes to the file at path (or nil, if path is empty), syncing it as each request is
+// recorded (see lift.NewJournal), and a function to call once the System is closed.
+func openJournal(path string, clock lift.Clock) (*lift.Journal, func()) {
+	if path == "" {
+		return nil, func() {}
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if a.Dir != dir {
-		panic(fmt.Sprintf("Waiting for %s lift, but pickup arrival says direction is %s", dir, a.Dir))
+	journal := lift.NewJournal(out, clock)
+	return journal, func() {
+		err := journal.Err()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
+}
 
-	// Board and press button.
-	chArrival = make(chan lift.Arrival) // For safety, we make a new channel for dropoff than for pickup.
-	log.Printf("Passenger-%d boarded Elevator-%d at %s %s\n", p.id, a.Conveyor.Id(), p.start, dir)
-	time.Sleep(lift.TimeSelectDropoff) // FUTURE: elevator door may close before passenger boards.
-	log.Printf("Passenger-%d requesting dropoff %s\n", p.id, p.dest)
-	dropoff := lift.Dropoff{p.dest, chArrival}
-	a.Conveyor.Dropoffs() <- dropoff
-	log.Printf("Passenger-%d riding to floor %s, waiting for dropoff on channel %v\n", p.id, p.dest, chArrival)
+// Prefixes each log line with the time of the (virtual) clock, relative to lift.Epoch.
+type clockWriter struct {
+	clock lift.Clock
+	w     io.Writer
+}
 
-	// Wait for arrival
-	a = <-chArrival
-	if a.Floor != p.dest {
-		panic(fmt.Sprintf("Passenger-%d waiting to arrive at at %s, but dropoff arrival says %s", p.id, p.dest, a.Floor))
+func (cw *clockWriter) Write(p []byte) (int, error) {
+	elapsed := cw.clock.Now().Sub(lift.Epoch)
+	if _, err := fmt.Fprintf(cw.w, "%12s ", elapsed.String()); err != nil {
+		return 0, err
 	}
-	log.Printf("Passenger-%d arrived at destination floor %s\n", p.id, p.dest)
+	return cw.w.Write(p)
 }
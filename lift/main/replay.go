@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"log"
+	"os"
+	"time"
+)
+
+// Rebuilds the System from the journal at path (see lift.Replay), up to until, and prints how it compares with
+// the journal, and the state of every car and hall call then. With snapshotPath, also writes its Snapshot there.
+// Returns false if the replay diverged from the journal.
+func replay(path string, until, tolerance time.Duration, snapshotPath string, clock *lift.VirtualClock, events lift.EventSink) bool {
+	in, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	r, err := lift.Replay(in, clock, until, tolerance, events)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, building := r.System, r.Building
+	at := func(rec *lift.JournalRecord) string {
+		action := "passing"
+		if rec.Stopping {
+			action = "stopping at"
+		}
+		return fmt.Sprintf("car %d %s %s at %v", rec.Car, action, building.Label(rec.Floor), rec.Time.Sub(r.Start))
+	}
+
+	fmt.Printf("Replayed %d requests from %s, to %v\n", r.Requests, path, r.Until.Sub(r.Start))
+	switch {
+	case r.Diverged != nil && r.Replayed != nil:
+		fmt.Printf("DIVERGED after %d drive records: the journal has %s, the replay %s\n", r.Drives-1, at(r.Diverged), at(r.Replayed))
+	case r.Diverged != nil:
+		fmt.Printf("DIVERGED after %d drive records: the journal has %s, the replay nothing more\n", r.Drives-1, at(r.Diverged))
+	case r.Replayed != nil:
+		fmt.Printf("DIVERGED after %d drive records: the journal has nothing more, the replay %s\n", r.Drives, at(r.Replayed))
+	default:
+		fmt.Printf("All %d drive records match\n", r.Drives)
+	}
+
+	for _, car := range s.Status() {
+		fmt.Printf("car %d: at %s %s, dest %s, doors %s, %d aboard, dropoffs %v, pickups up %v, down %v\n",
+			car.Id, building.Label(car.Floor), car.Dir, building.Label(car.Dest), car.Doors, car.Load,
+			labels(building, car.Dropoffs), labels(building, car.PickupsUp), labels(building, car.PickupsDown))
+	}
+	for _, hall := range s.HallCalls() {
+		fmt.Printf("hall call: %s %s, waiting %v, car %d\n", building.Label(hall.Floor), hall.Dir,
+			clock.Now().Sub(hall.Since), hall.Car)
+	}
+
+	if snapshotPath != "" {
+		if err := saveSnapshot(snapshotPath, s.Snapshot()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.Close()
+	clock.Stop()
+	return r.Diverged == nil && r.Replayed == nil
+}
+
+func labels(building *lift.Building, floors []lift.Floor) []string {
+	ls := make([]string, len(floors))
+	for i, f := range floors {
+		ls[i] = building.Label(f)
+	}
+	return ls
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"github.com/delliston/mygo/lift/api"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// The serve subcommand runs a System on the wall clock, and serves it over HTTP (see lift/api) until interrupted:
+//
+//	main serve -addr localhost:8080 -building lift/buildings/office.json
+//
+// With -state, the System resumes from the lift.Snapshot in that file (if there is one), and writes its
+// Snapshot there when it stops: a restarted controller keeps its hall calls and car calls.
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "the address to serve HTTP on")
+	buildingPath := flags.String("building", "", "JSON building description, see lift/buildings (default: 5 floors, 2 cars)")
+	dispatcherName := flags.String("dispatcher", "", "how hall calls are assigned to elevators (default: the building's)")
+	seed := flags.Int64("seed", 1, "seed for the random dispatcher")
+	eventsPath := flags.String("events", "", "write every event to this file, as JSON Lines")
+	statePath := flags.String("state", "", "resume from the snapshot in this file, and save one there on exit")
+	journalPath := flags.String("journal", "", "record every request (and every floor the cars pass) in this file")
+	flags.Parse(args)
+
+	building := loadBuilding(*buildingPath, *dispatcherName)
+	var clock lift.Clock = lift.RealClock{}
+	metrics := api.NewMetrics()
+	sinks := lift.MultiSink{metrics}
+	events, closeEvents := openEvents(*eventsPath)
+	if events != nil {
+		sinks = append(sinks, events)
+	}
+	snap, err := loadSnapshot(*statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	journal, closeJournal := openJournal(*journalPath, clock)
+	journal.Start(building, *seed, snap)
+	var s *lift.System
+	if snap != nil {
+		log.Printf("Resuming from %s: %d hall calls, taken at %v\n", *statePath, len(snap.HallCalls), snap.Time.Format(time.RFC3339))
+		s, err = building.NewSystemFromSnapshot(snap, clock, *seed, sinks, journal, nil)
+	} else {
+		s, err = building.NewSystem(clock, *seed, sinks, journal)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// On interrupt, end the event streams (and calls waiting for arrivals), and stop serving.
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{Addr: *addr, Handler: api.NewServer(s, building, clock, metrics),
+		BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		cancel()
+		server.Shutdown(context.Background())
+	}()
+
+	log.Printf("Building %q: %d floors, %d elevators, serving on http://%s\n", building.Name, building.Floors, len(s.Conveyors()), *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	if *statePath != "" {
+		if err := saveSnapshot(*statePath, s.Snapshot()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.Close()
+	closeEvents()
+	closeJournal()
+	log.Println("Stopped")
+}
+
+// Reads the Snapshot at path. Returns nil if there is no path, or no file there yet.
+func loadSnapshot(path string) (*lift.Snapshot, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snap lift.Snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &snap, nil
+}
+
+// Writes the Snapshot to path, by way of a temporary file, so that a crash leaves the previous one intact.
+func saveSnapshot(path string, snap *lift.Snapshot) error {
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	log.Printf("Saved snapshot to %s: %d hall calls\n", path, len(snap.HallCalls))
+	return os.Rename(path+".tmp", path)
+}
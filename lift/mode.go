@@ -0,0 +1,161 @@
+package lift
+
+import (
+	"fmt"
+	"log"
+)
+
+// Service modes: how a car takes part in the group. A car in any mode but ModeNormal takes no hall calls: when it
+// leaves normal service, it hands its hall calls back to the System, which dispatches them to the other cars.
+// When it returns, the System tries again the hall calls no car could take. See System.SetCarMode.
+type CarMode string
+
+const (
+	// The car takes hall calls and car calls.
+	ModeNormal CarMode = "normal"
+	// The car sets down its passengers, then parks with its doors shut, and refuses car calls.
+	ModeOutOfService CarMode = "out-of-service"
+	// Inspection: as ModeOutOfService, but once it has set down its passengers, the car moves when told to (see
+	// System.MoveCar), and only then. It stops with its doors shut.
+	ModeMaintenance CarMode = "maintenance"
+	// Independent service: the car serves car calls only, e.g. for a removal or an attendant.
+	ModeIndependent CarMode = "independent"
+)
+
+var CarModes = []CarMode{ModeNormal, ModeOutOfService, ModeMaintenance, ModeIndependent}
+
+// Returns an error if the CarMode is of no known kind.
+func (m CarMode) Validate() error {
+	for _, mode := range CarModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown car mode %q: must be one of %v", m, CarModes)
+}
+
+// Sent to a Conveyor to change its CarMode. It replies once it has. See System.SetCarMode.
+type ModeRequest struct {
+	Mode  CarMode
+	Reply chan<- struct{}
+}
+
+// Sent to a Conveyor in ModeMaintenance to move it to Floor. It replies at once: nil if it set off (or is there
+// already), or why not. See System.MoveCar.
+type MoveRequest struct {
+	Floor Floor
+	Reply chan<- error
+}
+
+// Returns true if we take no hall calls: we are out of normal service, or a fault stops us.
+func (e *Elevator) outOfGroup() bool {
+	return e.mode != ModeNormal || e.faulted()
+}
+
+// Returns true if we are out of service (or in maintenance), and have set down our passengers: we refuse car
+// calls, and stop with our doors shut.
+func (e *Elevator) withdrawn() bool {
+	return (e.mode == ModeOutOfService || e.mode == ModeMaintenance) && e.dropoffs.count() == 0 && !e.doorsBusy()
+}
+
+func (e *Elevator) onModeRequest(req ModeRequest) {
+	defer close(req.Reply)
+	if req.Mode == e.mode {
+		return
+	}
+	log.Printf("Elevator-%d mode %s -> %s at %s\n", e.id, e.mode, req.Mode, e.floor)
+	e.mode = req.Mode
+	e.events.emit(Event{Kind: EventMode, Car: e.id, Floor: e.floor, Mode: string(e.mode), Load: e.load.persons})
+	if e.mode == ModeNormal {
+		return
+	}
+	e.bypassPickups(fmt.Sprintf("in %s mode", e.mode))
+	if e.dir != IDLE && !e.doorsBusy() {
+		e.reconsiderDest() // We may have been heading for a pickup.
+	}
+}
+
+// Moves the car, in ModeMaintenance, once it has set down its passengers, and is at rest.
+func (e *Elevator) onMoveRequest(req MoveRequest) {
+	var err error
+	switch {
+	case e.mode != ModeMaintenance:
+		err = fmt.Errorf("car %d is in %s mode, not %s", e.id, e.mode, ModeMaintenance)
+	case !e.withdrawn():
+		err = fmt.Errorf("car %d is still setting down its passengers", e.id)
+	case e.dir != IDLE:
+		err = fmt.Errorf("car %d is on its way to %s", e.id, e.dest)
+	case !e.served.isSet(req.Floor):
+		err = fmt.Errorf("car %d does not serve %s", e.id, req.Floor)
+	case req.Floor != e.floor:
+		log.Printf("Elevator-%d moving to %s for maintenance\n", e.id, req.Floor)
+		e.gotoFloor(req.Floor)
+	}
+	req.Reply <- err
+}
+
+// Sets the mode of car id (see CarMode), and records it in the journal. Returns once the car is in the mode. When
+// the car leaves normal service, the System dispatches its hall calls to the other cars; when it returns, it tries
+// again those no car could take. Safe to call from any goroutine. After Close, it returns ErrClosed.
+func (s *System) SetCarMode(id int, mode CarMode) error {
+	if id < 0 || id >= len(s.elevators) {
+		return fmt.Errorf("no car %d", id)
+	}
+	if err := mode.Validate(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	expect(s.chModes)
+	select {
+	case s.chModes <- modeRequest{id, mode, done}:
+	case <-s.life.quit:
+		unexpect(s.chModes)
+		return ErrClosed
+	}
+	s.journal.mode(id, mode)
+	<-done
+	return nil
+}
+
+// Tells car id, in ModeMaintenance, to move to the floor; and records it in the journal. Returns an error if the
+// car is not in maintenance, is still setting down passengers or moving, or does not serve the floor. Safe to call
+// from any goroutine. After Close, it returns ErrClosed.
+func (s *System) MoveCar(id int, floor Floor) error {
+	if id < 0 || id >= len(s.elevators) {
+		return fmt.Errorf("no car %d", id)
+	}
+	if floor < 0 || floor > s.pickupsUp.maxFloor {
+		return fmt.Errorf("no floor %s", floor)
+	}
+	chReply := make(chan error, 1)
+	expect(s.elevators[id].MoveRequests())
+	select {
+	case s.elevators[id].MoveRequests() <- MoveRequest{floor, chReply}:
+	case <-s.life.quit:
+		unexpect(s.elevators[id].MoveRequests())
+		return ErrClosed
+	}
+	s.journal.move(id, floor)
+	return <-chReply
+}
+
+// Sent to the System's mainLoop by SetCarMode.
+type modeRequest struct {
+	car  int
+	mode CarMode
+	done chan<- struct{} // Closed once the car is in the mode.
+}
+
+// Changes the mode of a car. If it returns to normal service, it may take the hall calls no car could. It hands
+// its hall calls back itself when it leaves (see onModeRequest).
+func (s *System) onModeRequest(req modeRequest) {
+	log.Printf("System: Elevator-%d to %s mode\n", req.car, req.mode)
+	chReply := make(chan struct{})
+	expect(s.elevators[req.car].ModeRequests())
+	s.elevators[req.car].ModeRequests() <- ModeRequest{req.mode, chReply}
+	<-chReply
+	close(req.done)
+	if req.mode == ModeNormal {
+		s.retryUnassigned()
+	}
+}
@@ -0,0 +1,96 @@
+package lift
+
+import (
+	"testing"
+	"time"
+)
+
+// A car taken out of service hands back its hall calls, and refuses car calls. A call no car can take waits, until
+// a car returns to normal service.
+func TestOutOfServiceHandsBackCalls(t *testing.T) {
+	s, p, close := testSystem(6, DefaultCarSpecs(2))
+	defer close()
+
+	first := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 4, Dir: DOWN, Done: first}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCarMode(0, ModeOutOfService); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, first, 1, "4 DOWN") // Handed back by Elevator-0.
+	refused := doneChan(p)
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 3, Done: refused}); err != nil {
+		t.Fatal(err)
+	}
+	awaitOutcome(t, p, refused, Refused, 0, "car call to 3")
+
+	if err := s.SetCarMode(1, ModeOutOfService); err != nil {
+		t.Fatal(err)
+	}
+	second := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 2, Dir: UP, Done: second}); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+	if halls := s.HallCalls(); len(halls) != 1 || halls[0].Car != -1 {
+		t.Errorf("with both cars out of service, got hall calls %+v, want 2 UP unassigned", halls)
+	}
+	if err := s.SetCarMode(0, ModeNormal); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, second, 0, "2 UP")
+}
+
+// A car in independent service takes no hall calls, but serves car calls.
+func TestIndependentServesCarCalls(t *testing.T) {
+	s, p, close := testSystem(6, DefaultCarSpecs(2))
+	defer close()
+
+	if err := s.SetCarMode(0, ModeIndependent); err != nil {
+		t.Fatal(err)
+	}
+	pickup := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Done: pickup}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 1 {
+		t.Errorf("with Elevator-0 independent, 3 UP went to Elevator-%d, want Elevator-1", got)
+	}
+	dropoff := doneChan(p)
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 5, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	awaitArrival(t, p, pickup, 1, "3 UP")
+	awaitArrival(t, p, dropoff, 0, "car call to 5")
+}
+
+// A car in maintenance moves when told to, and only then, and stops with its doors shut. In any other mode, it
+// refuses to be moved.
+func TestMaintenanceMoves(t *testing.T) {
+	s, p, close := testSystem(6, DefaultCarSpecs(2))
+	defer close()
+
+	if err := s.MoveCar(0, 3); err == nil {
+		t.Errorf("Elevator-0 moved in normal service")
+	}
+	if err := s.SetCarMode(0, ModeMaintenance); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveCar(0, 3); err != nil {
+		t.Fatal(err)
+	}
+	p.Sleep(time.Minute)
+	status := s.Status()[0]
+	if status.Floor != 3 || status.Dir != IDLE || status.Doors != DoorsClosed {
+		t.Errorf("Elevator-0 is at %s going %s, doors %v: want at 3, IDLE, doors closed", status.Floor, status.Dir,
+			status.Doors)
+	}
+	if err := s.MoveCar(0, 6); err == nil {
+		t.Errorf("Elevator-0 moved to 6, which it does not serve")
+	}
+	p.Sleep(time.Minute)
+	if status := s.Status()[0]; status.Floor != 3 {
+		t.Errorf("Elevator-0 moved to %s untold", status.Floor)
+	}
+}
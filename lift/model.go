@@ -1,6 +1,7 @@
 package lift
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -18,8 +19,14 @@ const (
 type Floor int
 
 func (f Floor) String() string { return strconv.Itoa(int(f)) }
-func (f Floor) between(f1, f2 Floor) bool {
-	return f1 < f && f < f2
+func (f Floor) between(f1, f2 Floor) bool { // Strictly between f1 and f2, in either order.
+	return (f1 < f && f < f2) || (f2 < f && f < f1)
+}
+func (f Floor) distance(to Floor) int { // Number of floors between f and to.
+	if to > f {
+		return int(to - f)
+	}
+	return int(f - to)
 }
 func (f Floor) next(dir Direction) Floor {
 	return Floor(int(f) + int(dir))
@@ -54,6 +61,18 @@ func (d Direction) opposite() Direction {
 	}
 }
 
+// A Direction is written (e.g. in a Snapshot) as its String.
+func (d Direction) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+func (d *Direction) UnmarshalText(b []byte) error {
+	for _, dir := range []Direction{UP, IDLE, DOWN} {
+		if string(b) == dir.String() {
+			*d = dir
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown direction %q", b)
+}
+
 func (d Direction) String() string {
 	switch d {
 	case UP:
@@ -77,7 +96,16 @@ type FloorDir struct {
 type Pickup struct {
 	Floor Floor // The Pickup coordinates
 	Dir   Direction
-	Done  chan<- Arrival // On arrival at floor/dir, the Arrival is sent via Done.
+	Done  chan<- Arrival // On arrival at floor/dir, the Arrival is sent via Done. May be nil (System uses Conveyor.Arrivals()).
+	Dests []Floor        // Optional: where the passengers are going, if known. Cars which serve none of them are bypassed.
+	// Optional: how many are waiting, if known. Cars they would not fit, even empty, are bypassed; and if they fit in
+	// no car, the System refuses the pickup at once, with Outcome Oversized.
+	Persons int
+	Since   time.Time // When the hall call was made. Zero means now. Set by the System when it dispatches.
+	Aged    bool      // The pickup has waited too long (see CarSpec.MaxWait): serve it before any other request.
+	Call    int64     // The System's id for the hall call, for Events. Set by the System when it dispatches.
+	// Optional: identifies Done in a Snapshot, so that NewSystemFromSnapshot can find it again.
+	Listener string
 }
 
 func (p Pickup) String() string {
@@ -86,9 +114,16 @@ func (p Pickup) String() string {
 func (p Pickup) FloorDir() FloorDir { return FloorDir{p.Floor, p.Dir} } // for convenience
 
 // Used to request a Dropoff (from inside the Elevator). The dropoff is acknowledged by sending an Arrival.
+// Sending a Dropoff means the passengers boarded: if the car is too full for them (or does not serve the floor),
+// Done receives an Arrival at the current floor with Outcome Refused (or Unserved), and they must step out
+// and request another Pickup.
 type Dropoff struct {
-	Floor Floor          // The Dropoff floor
-	Done  chan<- Arrival // On arrival at floor, the arriving elevator is sent via Done.
+	Floor   Floor          // The Dropoff floor
+	Persons int            // How many passengers boarded with this request. Zero means one.
+	Kg      int            // Their total weight. Zero means Persons * AveragePassengerKg.
+	Done    chan<- Arrival // On arrival at floor, the arriving elevator is sent via Done.
+	// Optional: identifies Done in a Snapshot, as Pickup.Listener.
+	Listener string
 }
 
 func (d Dropoff) String() string {
@@ -100,13 +135,76 @@ type Arrival struct {
 	Floor    Floor     // The Pickup coordinates
 	Dir      Direction // May be IDLE, if the conveyor has no further dropoffs/pickups planned.
 	Conveyor Conveyor
+	Outcome  Outcome
+	Alighted int  // How many passengers got out here.
+	Load     int  // How many passengers are aboard, after those alighted.
+	Aged     bool // The car made this pickup late, by the aging rule. See CarSpec.MaxWait.
+}
+
+// What became of a request, as reported by its Arrival.
+type Outcome int
+
+const (
+	Served    Outcome = iota // The Conveyor arrived.
+	Refused                  // The car was full. Only for Dropoffs.
+	Unserved                 // The car does not stop at the floor. Only for Dropoffs.
+	Cancelled                // The System (or Conveyor) was closed first. Conveyor is nil if the System cancelled.
+	Oversized                // The passengers would not fit in the car (or, for a Pickup, any car) even if it were empty.
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Served:
+		return "SERVED"
+	case Refused:
+		return "REFUSED"
+	case Unserved:
+		return "UNSERVED"
+	case Cancelled:
+		return "CANCELLED"
+	case Oversized:
+		return "OVERSIZED"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
 }
 
 func (a Arrival) FloorDir() FloorDir { return FloorDir{a.Floor, a.Dir} } // for convenience
 
 type Requestor interface {
-	// Returns a channel to which Pickup requests can be sent.
+	// Returns a channel to which Pickup requests can be sent. After Close, nothing receives: use SendPickup.
 	Pickups() chan<- Pickup
+
+	// Returns a channel which is closed when the Requestor is closed.
+	Closed() <-chan struct{}
+}
+
+// Returned by SendPickup, SendDropoff, and the System's InjectFault, SetCarMode and MoveCar, if the System (or
+// Conveyor) was closed first.
+var ErrClosed = errors.New("lift: closed")
+
+// Sends the Pickup request to r; or returns ErrClosed if r was closed first. Safe to call from any goroutine.
+func SendPickup(r Requestor, pickup Pickup) error {
+	expect(r.Pickups())
+	select {
+	case r.Pickups() <- pickup:
+		return nil
+	case <-r.Closed():
+		unexpect(r.Pickups())
+		return ErrClosed
+	}
+}
+
+// Sends the Dropoff request to c; or returns ErrClosed if c was closed first. Safe to call from any goroutine.
+func SendDropoff(c Conveyor, dropoff Dropoff) error {
+	expect(c.Dropoffs())
+	select {
+	case c.Dropoffs() <- dropoff:
+		return nil
+	case <-c.Closed():
+		unexpect(c.Dropoffs())
+		return ErrClosed
+	}
 }
 
 // A Conveyor (e.g., Elevator) is represented by a set of channels which the consumer can use:
@@ -117,34 +215,162 @@ type Conveyor interface {
 
 	Id() int // May not be needed
 
-	// Returns a channel to which Dropoff requests can be sent.
+	// Returns a channel to which Dropoff requests can be sent. After Close, nothing receives: use SendDropoff.
 	Dropoffs() chan<- Dropoff
 
-	// Returns a channel to which all arrivals are sent.  TODO: Not needed.
+	// Returns a channel to which all arrivals (with a direction) are sent. It must be drained: the System does this.
 	Arrivals() <-chan Arrival
 
-	// FUTURE
-	//  PickupCancellations() chan<- Pickup (?) -- when another elevator makes the pickup, the System should cancel it everywhere.
-	//	PickupQueries() chan<- FloorDir // Writable channel: query pickup, Conveyance replies with PickupEstimate
-	//	PickupQueryEstimates() <-chan PickupEstimate
+	// Returns a channel to which PickupQueries can be sent. The Conveyor replies with a PickupEstimate,
+	// but does not commit to the pickup: the System sends the Pickup to the best offer.
+	PickupQueries() chan<- PickupQuery
+
+	// Returns a channel on which the Conveyor hands back Pickups it will not make after all (e.g., the car is full),
+	// so the System can dispatch them to another Conveyor. It must be drained: the System does this.
+	PickupReturns() <-chan Pickup
+
+	// Returns a channel to which Pickups are sent when another Conveyor has made the pickup.
+	// The Conveyor forgets the pickup (Done is not notified), and need not go there any more.
+	PickupCancellations() chan<- Pickup
+
+	// Returns a channel to which StatusQueries can be sent. The Conveyor replies with its CarStatus.
+	StatusQueries() chan<- StatusQuery
+
+	// Returns a channel to which StatusWatches can be sent. A watching channel receives the CarStatus at once, and
+	// again whenever it changes. The Conveyor does not wait for it: if it is full, that CarStatus is dropped.
+	StatusWatches() chan<- StatusWatch
+
+	// Returns a channel to which SnapshotQueries can be sent. The Conveyor replies with its CarSnapshot.
+	SnapshotQueries() chan<- SnapshotQuery
+
+	// Returns a channel to which Faults can be sent, to inject them into the car's hardware. See System.InjectFault.
+	FaultInjections() chan<- Fault
+
+	// Returns a channel on which the Conveyor reports the faults it detects, and their clearing. It must be
+	// drained: the System does this.
+	Faults() <-chan FaultReport
+
+	// Returns a channel to which ModeRequests can be sent, to change the car's CarMode. See System.SetCarMode.
+	ModeRequests() chan<- ModeRequest
+
+	// Returns a channel to which MoveRequests can be sent, to move the car in ModeMaintenance. See System.MoveCar.
+	MoveRequests() chan<- MoveRequest
+
+	// Stops the Conveyor, and waits until its goroutines have returned. Requests not yet served are rejected:
+	// their Done channels receive an Arrival with Outcome Cancelled (so they must still be received from).
+	// Calling Close again does nothing.
+	Close()
+}
+
+// Sent to a Conveyor to request a PickupEstimate. Best offer will be sent the Pickup.
+type PickupQuery struct {
+	Pickup Pickup
+	Reply  chan<- PickupEstimate
 }
 
-// FUTURE: Choosing the right pickup.
-// - Add channel to Conveyor interface for querying pickup estimate.
+// Sent to a Conveyor to ask for its CarStatus.
+type StatusQuery struct {
+	Reply chan<- CarStatus
+}
+
+// Sent to a Conveyor to ask for its CarSnapshot. See System.Snapshot.
+type SnapshotQuery struct {
+	Reply chan<- CarSnapshot
+}
 
-// Sent to Elevator to request a PickupEstimate. Best offer will be sent a Pickup.
-//type PickupQuery struct {
-//	pickup Pickup
-//	chResp chan<- PickupEstimate
-//}
+// Sent to a Conveyor to watch its CarStatus (or, if Watch is false, to stop).
+type StatusWatch struct {
+	Ch    chan<- CarStatus
+	Watch bool
+}
 
-// FUTURE: how to estimate best pickup? Fields suggest some potential values.
-/*
+// A snapshot of a car, e.g. for display.
+type CarStatus struct {
+	Id          int
+	Floor       Floor     // As Elevator.floor: the floor it stands at, or the last floor passed.
+	Dest        Floor     // Equal to Floor if the car is IDLE.
+	Dir         Direction // Which way the car is travelling (or, with its doors open, will leave).
+	Doors       DoorState
+	Load        int // Passengers aboard.
+	Dropoffs    []Floor
+	PickupsUp   []Floor
+	PickupsDown []Floor
+	Faults      []FaultKind // Detected, and not yet cleared, in the order of FaultKinds.
+	Mode        CarMode
+}
+
+func (cs CarStatus) equal(other CarStatus) bool {
+	return cs.Id == other.Id && cs.Floor == other.Floor && cs.Dest == other.Dest && cs.Dir == other.Dir &&
+		cs.Doors == other.Doors && cs.Load == other.Load && equalFloors(cs.Dropoffs, other.Dropoffs) &&
+		equalFloors(cs.PickupsUp, other.PickupsUp) && equalFloors(cs.PickupsDown, other.PickupsDown) &&
+		equalFaults(cs.Faults, other.Faults) && cs.Mode == other.Mode
+}
+
+func equalFaults(a, b []FaultKind) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFloors(a, b []Floor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A snapshot of an outstanding hall call, e.g. for display.
+type HallCallStatus struct {
+	Floor   Floor
+	Dir     Direction
+	Waiting int       // How many passengers (or groups) are waiting for it.
+	Since   time.Time // When the first of them called.
+	Car     int       // The id of the car it is dispatched to, or -1 if none could take it yet.
+}
+
+// How much it would cost a Conveyor to serve a Pickup, given the requests it already has.
 type PickupEstimate struct {
-	floor Pickup
-	stopsUntilPickup int         // How many stops elevator would make before pickup.
-	distanceUntilPickup int      // How many floors elevator would travel before pickup.
-	goinThereAnyway bool	// True if the pickup is on the way (and in correct direction) to the elevator's
-							// current destination
+	Pickup              Pickup
+	Conveyor            Conveyor
+	Floor               Floor         // Where the elevator is now (the last floor passed, if moving).
+	Pending             int           // How many stops (dropoffs and pickups) the elevator already has outstanding.
+	Load                int           // How many passengers are aboard.
+	Assigned            int           // How many wait at the hall calls dispatched to the elevator (at least one a call). Set by the System.
+	StopsUntilPickup    int           // How many stops the elevator would make before pickup.
+	DistanceUntilPickup int           // How many floors the elevator would travel before pickup.
+	GoingThereAnyway    bool          // True if the pickup is on the way (and in correct direction) to the elevator's current destination
+	Bypass              bool          // True if the elevator will not take the pickup now (e.g., it is full). The System skips it.
+	TimePerFloor        time.Duration // How long the elevator takes to travel one floor. Zero means TimeBetweenFloors.
+	TimePerStop         time.Duration // How long the elevator takes to serve a stop. Zero means TimeServiceFloor.
+}
+
+// Estimated time until the Conveyor arrives at the Pickup.
+func (pe PickupEstimate) Cost() time.Duration {
+	perFloor, perStop := pe.TimePerFloor, pe.TimePerStop
+	if perFloor == 0 {
+		perFloor = TimeBetweenFloors
+	}
+	if perStop == 0 {
+		perStop = TimeServiceFloor
+	}
+	return time.Duration(pe.StopsUntilPickup)*perStop + time.Duration(pe.DistanceUntilPickup)*perFloor
+}
+
+// Returns true if pe is a better offer than other: cheaper, or equally cheap and going there anyway.
+func (pe PickupEstimate) betterThan(other PickupEstimate) bool {
+	if pe.Cost() != other.Cost() {
+		return pe.Cost() < other.Cost()
+	}
+	return pe.GoingThereAnyway && !other.GoingThereAnyway
 }
-*/
@@ -0,0 +1,173 @@
+package lift
+
+import (
+	"math"
+	"time"
+)
+
+// How a car moves: it accelerates to its rated Speed, cruises, and brakes to stop at its destination.
+// The acceleration changes no faster than Jerk allows, for the comfort of the passengers (an S-curve profile).
+type MotionProfile struct {
+	Speed        float64 // Rated speed, in metres per second.
+	Acceleration float64 // The most acceleration (and deceleration), in m/s². Zero means the car reaches Speed at once.
+	Jerk         float64 // How fast the acceleration may change, in m/s³. Zero means at once.
+}
+
+// At rated speed, a default car takes TimeBetweenFloors per DefaultFloorHeight.
+// Its acceleration and jerk are typical of passenger elevators.
+var DefaultMotion = MotionProfile{Speed: DefaultFloorHeight / TimeBetweenFloors.Seconds(), Acceleration: 1, Jerk: 1.6}
+
+// With the default speed, a car takes TimeBetweenFloors per floor.
+const DefaultFloorHeight = 3.5
+
+// Returns the level (in metres, from the bottom floor) of each of numFloors floors, DefaultFloorHeight apart.
+func defaultLevels(numFloors int) []float64 {
+	levels := make([]float64, numFloors)
+	for i := range levels {
+		levels[i] = float64(i) * DefaultFloorHeight
+	}
+	return levels
+}
+
+// How long the car takes to travel one floor of the given height, at rated speed.
+func (mp MotionProfile) cruiseTime(height float64) time.Duration { return seconds(height / mp.Speed) }
+
+// How much longer a stop makes the journey, than cruising past the floor: the time lost braking and accelerating.
+func (mp MotionProfile) stopTime() time.Duration {
+	jerkTime, accelTime := mp.accelPhase(mp.Speed)
+	return seconds(2*jerkTime + accelTime)
+}
+
+// Returns how long the car accelerates from rest to speed v: in two jerk segments (while the acceleration
+// rises and falls) and in between, at constant acceleration.
+func (mp MotionProfile) accelPhase(v float64) (jerkTime, accelTime float64) {
+	switch {
+	case mp.Acceleration <= 0:
+		return 0, 0
+	case mp.Jerk <= 0:
+		return 0, v / mp.Acceleration
+	case v >= mp.Acceleration*mp.Acceleration/mp.Jerk:
+		return mp.Acceleration / mp.Jerk, v/mp.Acceleration - mp.Acceleration/mp.Jerk
+	default:
+		return math.Sqrt(v / mp.Jerk), 0 // The car reaches v before it reaches full acceleration.
+	}
+}
+
+// The distance the car travels while accelerating from rest to speed v (or braking from v to rest).
+func (mp MotionProfile) accelDistance(v float64) float64 {
+	jerkTime, accelTime := mp.accelPhase(v)
+	return v * (2*jerkTime + accelTime) / 2 // The speed rises symmetrically, so it averages v/2.
+}
+
+// A movement of a car from rest to rest, over some distance. Times are in seconds from the start,
+// and positions in metres from the origin.
+type motionRun struct {
+	profile    MotionProfile
+	distance   float64
+	peak       float64 // The top speed. Less than the rated speed if the distance is too short to reach it.
+	jerkTime   float64 // The duration of each jerk segment.
+	accelTime  float64 // The duration of constant acceleration, in each of the acceleration and braking phases.
+	cruiseTime float64 // The duration at peak speed.
+}
+
+func newMotionRun(mp MotionProfile, distance float64) motionRun {
+	r := motionRun{profile: mp, distance: distance, peak: mp.Speed}
+	if distance <= 0 {
+		return motionRun{profile: mp}
+	}
+	if full := 2 * mp.accelDistance(mp.Speed); distance < full {
+		// Too short to reach rated speed: find the peak speed which covers the distance.
+		lo, hi := 0.0, mp.Speed
+		for i := 0; i < 64; i++ {
+			mid := (lo + hi) / 2
+			if 2*mp.accelDistance(mid) < distance {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		r.peak = lo
+	}
+	r.jerkTime, r.accelTime = mp.accelPhase(r.peak)
+	r.cruiseTime = (distance - 2*mp.accelDistance(r.peak)) / r.peak
+	if r.cruiseTime < 0 {
+		r.cruiseTime = 0
+	}
+	return r
+}
+
+func (r motionRun) accelDuration() float64 { return 2*r.jerkTime + r.accelTime }
+func (r motionRun) duration() float64      { return 2*r.accelDuration() + r.cruiseTime }
+
+// Until this time, the run is the same as any longer run: the car is still speeding up (or cruising) as fast
+// as it can. After it, the car is braking for the destination (or easing off for a short run).
+func (r motionRun) commitTime() float64 {
+	if r.distance <= 0 {
+		return 0
+	}
+	if r.peak < r.profile.Speed*(1-1e-9) {
+		return r.jerkTime + r.accelTime // It eases off the acceleration early, never reaching rated speed.
+	}
+	return r.accelDuration() + r.cruiseTime
+}
+
+// Returns true if, at time t, the car on this run could instead brake for the end of the other run (which starts
+// from the same origin, in the same direction). Both runs are the same until either commits.
+func (r motionRun) canSwitch(t float64, other motionRun) bool {
+	const epsilon = 1e-6 // A millionth of a second, for rounding.
+	return t <= math.Min(r.commitTime(), other.commitTime())+epsilon
+}
+
+// The position at time t.
+func (r motionRun) position(t float64) float64 {
+	switch total := r.duration(); {
+	case t <= 0:
+		return 0
+	case t >= total:
+		return r.distance
+	case t < r.accelDuration():
+		return r.accelPosition(t)
+	case t < r.accelDuration()+r.cruiseTime:
+		return r.profile.accelDistance(r.peak) + r.peak*(t-r.accelDuration())
+	default:
+		return r.distance - r.accelPosition(total-t) // Braking mirrors accelerating.
+	}
+}
+
+// The position at time t of the acceleration phase.
+func (r motionRun) accelPosition(t float64) float64 {
+	jerk := r.profile.Jerk
+	accel := r.profile.Acceleration
+	if r.jerkTime > 0 {
+		accel = jerk * r.jerkTime // The peak acceleration.
+	}
+	if t <= r.jerkTime {
+		return jerk * t * t * t / 6
+	}
+	v1, x1 := jerk*r.jerkTime*r.jerkTime/2, jerk*r.jerkTime*r.jerkTime*r.jerkTime/6
+	if t -= r.jerkTime; t <= r.accelTime {
+		return x1 + v1*t + accel*t*t/2
+	}
+	v2, x2 := v1+accel*r.accelTime, x1+v1*r.accelTime+accel*r.accelTime*r.accelTime/2
+	t -= r.accelTime
+	return x2 + v2*t + accel*t*t/2 - jerk*t*t*t/6
+}
+
+// The time at which the car reaches position x.
+func (r motionRun) timeAt(x float64) float64 {
+	if x >= r.distance {
+		return r.duration()
+	}
+	lo, hi := 0.0, r.duration()
+	for i := 0; i < 64; i++ {
+		mid := (lo + hi) / 2
+		if r.position(mid) < x {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi
+}
+
+func seconds(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
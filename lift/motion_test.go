@@ -0,0 +1,70 @@
+package lift
+
+import (
+	"math"
+	"testing"
+)
+
+// A profile with round numbers: the car reaches full acceleration in 1s, and rated speed 1s later, 3m on.
+var testMotion = MotionProfile{Speed: 2, Acceleration: 1, Jerk: 1}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+// A run reaches rated speed if it is long enough, and its end mirrors its start.
+func TestMotionRun(t *testing.T) {
+	shortPeak := (math.Sqrt(17) - 1) / 2 // Solves 2 * accelDistance(v) = 4.
+	for _, c := range []struct {
+		distance, peak, duration, commit float64
+	}{
+		{10, 2, 8, 5}, // Cruises for 2s.
+		{6, 2, 6, 3},  // Brakes as soon as it reaches rated speed.
+		{4, shortPeak, 2 * (2 + shortPeak - 1), shortPeak}, // Eases off before full speed.
+		{0, 0, 0, 0},
+	} {
+		r := newMotionRun(testMotion, c.distance)
+		if !near(r.peak, c.peak) || !near(r.duration(), c.duration) || !near(r.commitTime(), c.commit) {
+			t.Errorf("%vm: got peak %v, duration %v, commit %v; want %v, %v, %v",
+				c.distance, r.peak, r.duration(), r.commitTime(), c.peak, c.duration, c.commit)
+		}
+		if got := r.position(r.duration()); !near(got, c.distance) {
+			t.Errorf("%vm: the car ends at %v", c.distance, got)
+		}
+		if got := r.position(r.duration() / 2); !near(got, c.distance/2) {
+			t.Errorf("%vm: half way through, the car is at %v, want %v", c.distance, got, c.distance/2)
+		}
+		for x := 0.0; x < c.distance; x += 0.5 {
+			if got := r.position(r.timeAt(x)); !near(got, x) {
+				t.Errorf("%vm: at timeAt(%v), the car is at %v", c.distance, x, got)
+			}
+		}
+	}
+}
+
+// Two runs from the same place are the same until the car brakes for the nearer end (or eases off, short of rated
+// speed): until then, it can switch from one to the other.
+func TestCanSwitch(t *testing.T) {
+	short, long, longer := newMotionRun(testMotion, 4), newMotionRun(testMotion, 10), newMotionRun(testMotion, 20)
+	for tm := 0.0; tm <= short.commitTime(); tm += 0.1 {
+		if !near(short.position(tm), long.position(tm)) {
+			t.Errorf("at %vs, the short run is at %v, the long at %v", tm, short.position(tm), long.position(tm))
+		}
+	}
+	for _, c := range []struct {
+		from, to motionRun
+		t        float64
+		want     bool
+	}{
+		{long, short, 1.5, true},
+		{long, short, short.commitTime(), true},
+		{long, short, 1.6, false}, // The car would overshoot.
+		{short, long, 1.5, true},
+		{short, long, 1.6, false}, // The car is easing off.
+		{long, longer, 4.9, true}, // Cruising.
+		{long, longer, 5.1, false},
+		{longer, long, 5.1, false}, // The car cannot brake in time.
+	} {
+		if got := c.from.canSwitch(c.t, c.to); got != c.want {
+			t.Errorf("from %vm at %vs, canSwitch to %vm = %v, want %v", c.from.distance, c.t, c.to.distance, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package lift
+
+import (
+	"fmt"
+)
+
+// A StopPolicy chooses where a car stops next, from its requests. The Elevator asks when its doors close,
+// and whenever it gets a new request on the way (it stops short of its destination, if the policy says so).
+// When the car stops, it serves the dropoffs there, and the pickups in its direction. So a policy which
+// stops for a pickup in the other direction should return the car's floor, to turn around for it.
+// Implementations are called from the Elevator's goroutine only.
+type StopPolicy interface {
+	// Returns the next stop, and true; or false if the car has nothing to do.
+	NextStop(c CarState) (Floor, bool)
+}
+
+// What a StopPolicy knows about a car. The policy must not change the FloorSets.
+type CarState struct {
+	Floor       Floor     // Where the car stands, or the last floor passed. It has been served.
+	Dir         Direction // IDLE if the car has no direction (e.g. it was idle, and its first passengers boarded).
+	Dropoffs    *FloorSet
+	PickupsUp   *FloorSet
+	PickupsDown *FloorSet
+	Served      *FloorSet // The floors the car may stop at.
+}
+
+func (c CarState) pickups(dir Direction) *FloorSet {
+	if dir == UP {
+		return c.PickupsUp
+	}
+	return c.PickupsDown
+}
+
+// Returns the nearest request of any kind, either way. Ties go UP.
+func (c CarState) nearestEitherWay() (Floor, bool) {
+	up, okUp := nearestInFloorSets(c.Floor, UP, c.Dropoffs, c.PickupsUp, c.PickupsDown)
+	down, okDown := nearestInFloorSets(c.Floor, DOWN, c.Dropoffs, c.PickupsUp, c.PickupsDown)
+	if okUp && (!okDown || c.Floor.distance(up) <= c.Floor.distance(down)) {
+		return up, true
+	}
+	return down, okDown
+}
+
+// Returns true if the car has any request.
+func (c CarState) busy() bool {
+	return c.Dropoffs.count()+c.PickupsUp.count()+c.PickupsDown.count() > 0
+}
+
+// Names accepted by NewStopPolicy.
+var StopPolicyNames = []string{"collective", "scan", "look", "ssf"}
+
+// Returns a built-in StopPolicy by name. "" means collective.
+func NewStopPolicy(name string) (StopPolicy, error) {
+	switch name {
+	case "collective", "":
+		return CollectiveSelective{}, nil
+	case "scan":
+		return SCAN{}, nil
+	case "look":
+		return LOOK{}, nil
+	case "ssf":
+		return ShortestSeekFirst{}, nil
+	default:
+		return nil, fmt.Errorf("unknown stop policy %q (want one of %v)", name, StopPolicyNames)
+	}
+}
+
+type CollectiveSelective struct{}
+
+// CollectiveSelective answers hall calls in the car's direction of travel, and car calls, on its way.
+// We will continue in current direction if any dropoffs, pickups lay in that direction.
+func (CollectiveSelective) NextStop(c CarState) (dest Floor, ok bool) {
+	if c.Dir == IDLE {
+		// We have no direction (e.g. passengers boarded an idle car): go to the nearest request either way.
+		return c.nearestEitherWay()
+	}
+
+	floor := c.Floor
+	dir := c.Dir
+	dirOpposite := c.Dir.opposite()
+
+	// Determine next stop. In priority order, this is:
+
+	// 1. The nearest dropoff or pickup (where pickup.dir == current direction)
+	// 		which lies beyond c.Floor in current direction.
+	dest, ok = nearestInFloorSets(floor, dir, c.Dropoffs, c.pickups(dir))
+	if ok {
+		return
+	}
+
+	// 2. The furthest pickup (where pickup.dir == OPPOSITE direction)
+	// 		which lies AT OR beyond c.Floor in current direction.
+	dest, ok = c.pickups(dirOpposite).furthest(floor, dir)
+	if ok {
+		return
+	}
+
+	// OK, there's in our current direction. Find something in the other direction.
+
+	// 3. The nearest dropoff or pickup (where pickup.dir == OPPOSITE direction)
+	// 		which lies beyond c.Floor in OPPOSITE direction
+	dest, ok = nearestInFloorSets(floor, dirOpposite, c.Dropoffs, c.pickups(dirOpposite)) // REVIEW: was pickups(dir)
+	if ok {
+		return
+	}
+
+	// 4. The furthest pickup (where pickup.dir == current direction)
+	// 		which lies AT OR beyond c.Floor in OPPOSITE direction
+	//	  E.g., if dir == DOWN, find the highest pickup.
+	dest, ok = c.pickups(dir).furthest(floor, dirOpposite) // REVIEW: was pickups(dirOpposite)
+	if ok {
+		return
+	}
+
+	// FUTURE: Does that cover all cases?
+
+	return InvalidFloor, false
+}
+
+// SCAN sweeps from one end of the shaft to the other, and back, while it has any request. On the way, it
+// stops like CollectiveSelective; but it only turns around at the last floor it serves.
+type SCAN struct{}
+
+func (SCAN) NextStop(c CarState) (Floor, bool) {
+	if c.Dir == IDLE {
+		return c.nearestEitherWay()
+	}
+	if dest, ok := nearestInFloorSets(c.Floor, c.Dir, c.Dropoffs, c.pickups(c.Dir)); ok {
+		return dest, true
+	}
+	if !c.busy() {
+		return InvalidFloor, false
+	}
+	end, _ := c.Served.furthest(c.Floor, c.Dir)
+	if end != c.Floor && end != InvalidFloor {
+		return end, true // On to the end, even though nobody wants to go there.
+	}
+	// At the end: turn around, for a pickup here, or else carry on the other way.
+	if c.pickups(c.Dir.opposite()).isSet(c.Floor) {
+		return c.Floor, true
+	}
+	return nearestInFloorSets(c.Floor, c.Dir.opposite(), c.Dropoffs, c.PickupsUp, c.PickupsDown)
+}
+
+// LOOK stops at every request ahead, whichever way its passengers want to go, and turns around after
+// the last one. Unlike CollectiveSelective, it stops for hall calls in the other direction on its way,
+// although those passengers will not board until it comes back (or turns around there).
+type LOOK struct{}
+
+func (LOOK) NextStop(c CarState) (Floor, bool) {
+	if c.Dir == IDLE {
+		return c.nearestEitherWay()
+	}
+	if dest, ok := nearestInFloorSets(c.Floor, c.Dir, c.Dropoffs, c.PickupsUp, c.PickupsDown); ok {
+		return dest, true
+	}
+	if c.pickups(c.Dir.opposite()).isSet(c.Floor) {
+		return c.Floor, true // The last request: turn around here.
+	}
+	return nearestInFloorSets(c.Floor, c.Dir.opposite(), c.Dropoffs, c.PickupsUp, c.PickupsDown)
+}
+
+// ShortestSeekFirst always goes to the nearest request, whichever direction it lies in. It is greedy:
+// requests at the far end of the building may wait a long time.
+type ShortestSeekFirst struct{}
+
+func (ShortestSeekFirst) NextStop(c CarState) (Floor, bool) {
+	if c.Dir != IDLE && c.pickups(c.Dir.opposite()).isSet(c.Floor) {
+		return c.Floor, true // Nearest of all: turn around here.
+	}
+	return c.nearestEitherWay()
+}
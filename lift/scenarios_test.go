@@ -0,0 +1,415 @@
+package lift
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The direction scenarios run one car of a small building through every start state (idle, moving, doors open)
+// at every floor, and every pair of hall calls (each floor and direction, made at once or a floor's travel
+// later), until every passenger has arrived. They check the direction commitment rules (see elevator.go).
+
+// The state of the car when the scenario's calls are made.
+type scenarioStart struct {
+	kind  string    // "idle", "moving" or "doors".
+	floor Floor     // The car is at floor (or, if moving, has just left it).
+	to    Floor     // moving: where the passenger aboard is going.
+	dir   Direction // doors: the doors are open for a pickup this way.
+}
+
+func (s scenarioStart) String() string {
+	switch s.kind {
+	case "moving":
+		return fmt.Sprintf("moving %s->%s", s.floor, s.to)
+	case "doors":
+		return fmt.Sprintf("doors open at %s %s", s.floor, s.dir)
+	default:
+		return fmt.Sprintf("idle at %s", s.floor)
+	}
+}
+
+// A hall call, and where its passenger goes.
+type scenarioCall struct {
+	floor Floor
+	dir   Direction
+	dest  Floor
+	delay time.Duration // After the start state.
+}
+
+func (c scenarioCall) String() string {
+	return fmt.Sprintf("%s %s->%s @%v", c.floor, c.dir, c.dest, c.delay)
+}
+
+type scenario struct {
+	floors int
+	policy string
+	start  scenarioStart
+	calls  []scenarioCall
+}
+
+func (sc scenario) String() string {
+	calls := make([]string, len(sc.calls))
+	for i, c := range sc.calls {
+		calls[i] = c.String()
+	}
+	return fmt.Sprintf("%s, %d floors, %s, calls [%s]", sc.policy, sc.floors, sc.start, strings.Join(calls, ", "))
+}
+
+// The arrivals of one stop of the car: one door cycle.
+type scenarioStop struct {
+	at       time.Time
+	floor    Floor
+	dirs     []Direction
+	useful   bool        // The car let somebody out or in.
+	pickedUp []Direction // The pickups it made.
+}
+
+func (s scenarioStop) String() string {
+	useful := ""
+	if !s.useful {
+		useful = " (nobody)"
+	}
+	return fmt.Sprintf("%s %v%s", s.floor, s.dirs, useful)
+}
+
+// Returns the scenarios for a building of numFloors.
+func directionScenarios(numFloors int, policy string) []scenario {
+	top := Floor(numFloors - 1)
+	end := func(dir Direction) Floor { // The last floor in the direction.
+		if dir == UP {
+			return top
+		}
+		return 0
+	}
+	var floorDirs []FloorDir
+	for f := Floor(0); f <= top; f++ {
+		for _, dir := range []Direction{UP, DOWN} {
+			if f != end(dir) {
+				floorDirs = append(floorDirs, FloorDir{f, dir})
+			}
+		}
+	}
+
+	var starts []scenarioStart
+	for f := Floor(0); f <= top; f++ {
+		starts = append(starts, scenarioStart{kind: "idle", floor: f})
+		for to := Floor(0); to <= top; to++ {
+			if to != f {
+				starts = append(starts, scenarioStart{kind: "moving", floor: f, to: to})
+			}
+		}
+	}
+	for _, fd := range floorDirs {
+		starts = append(starts, scenarioStart{kind: "doors", floor: fd.floor, dir: fd.dir})
+	}
+
+	var scenarios []scenario
+	for _, start := range starts {
+		var first []scenarioCall
+		var delay time.Duration
+		if start.kind == "doors" {
+			first = []scenarioCall{{start.floor, start.dir, end(start.dir), 0}}
+			delay = DefaultDoorTimes.Opening + 5*Tick // The calls come while the doors are open.
+		}
+		for _, c1 := range floorDirs {
+			dests := []Floor{end(c1.dir)}
+			if wrongWay := end(c1.dir.opposite()); wrongWay != c1.floor {
+				dests = append(dests, wrongWay) // The passenger boards, then asks to go the other way.
+			}
+			for _, dest := range dests {
+				calls := append(append([]scenarioCall{}, first...), scenarioCall{c1.floor, c1.dir, dest, delay})
+				scenarios = append(scenarios, scenario{numFloors, policy, start, calls})
+				for _, c2 := range floorDirs {
+					if c2 == c1 {
+						continue
+					}
+					for _, later := range []time.Duration{0, TimeBetweenFloors} {
+						call := scenarioCall{c2.floor, c2.dir, end(c2.dir), delay + later}
+						scenarios = append(scenarios, scenario{numFloors, policy, start, append(append([]scenarioCall{}, calls...), call)})
+					}
+				}
+			}
+		}
+	}
+	return scenarios
+}
+
+// Collects the log of a scenario, and (as the EventSink of its car) counts the car's door cycles.
+type scenarioLog struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	cycles int
+}
+
+func (l *scenarioLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *scenarioLog) Event(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ev.Kind == EventDoorsOpening {
+		l.cycles++
+	}
+}
+
+func (l *scenarioLog) doorCycles() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cycles
+}
+
+func (l *scenarioLog) warned() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return bytes.Contains(l.buf.Bytes(), []byte("WARNING"))
+}
+
+// Runs the scenario, and checks the rules. The car logs, and sends its Events, to logs.
+func runScenario(sc scenario, logs *scenarioLog) error {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	spec := DefaultCarSpec
+	spec.StopPolicy, _ = NewStopPolicy(sc.policy)
+	e := newElevator(0, sc.floors, spec, clock, newEventLog(logs, clock), nil)
+	defer e.Close()
+
+	// The Done channels are buffered, so that the car never waits for us (even when it cancels on Close).
+	chPickups := make(chan Arrival, len(sc.calls)+1)
+	chDropoffs := make(chan Arrival, len(sc.calls)+2)
+	p := Join(clock, chPickups, chDropoffs, e.Arrivals()) // We are the car's passengers, and its System.
+	defer p.Leave()
+	deadline := clock.After(10 * time.Minute)
+	var stops []scenarioStop
+
+	// Go to the start floor, and wait for the doors to close.
+	if sc.start.floor != 0 {
+		SendDropoff(e, Dropoff{Floor: sc.start.floor, Done: chDropoffs})
+		p.Idle()
+		<-chDropoffs
+	}
+	settled := clock.After(TimeServiceFloor + 10*Tick)
+	for settled != nil {
+		p.Idle(settled)
+		select {
+		case <-e.Arrivals():
+		case <-settled:
+			settled = nil
+		}
+	}
+
+	aboard := make(map[Floor]int) // Passengers aboard, by dest.
+	if sc.start.kind == "moving" {
+		SendDropoff(e, Dropoff{Floor: sc.start.to, Done: chDropoffs})
+		aboard[sc.start.to]++
+	}
+	waiting := make(map[FloorDir][]scenarioCall) // Calls made, and not yet picked up.
+	// The pickup Arrival comes both to the passengers and via e.Arrivals(), in either order. We credit the stop
+	// with the pickup once: on the first, if the passengers have boarded already (uncredited), or else on the second.
+	credited := make(map[FloorDir]bool)
+	uncredited := make(map[FloorDir]bool)
+	boarded := make(map[Floor]map[Floor]int) // Passengers aboard from an uncredited pickup, by floor and dest.
+	pending := len(sc.calls) + len(aboard)   // Passengers who have not arrived.
+	var next <-chan time.Time                // When the next call is made.
+	calls := sc.calls
+	start := clock.Now()
+	cycles := logs.doorCycles()
+
+	for pending > 0 {
+		// Make the calls which are due.
+		for len(calls) > 0 && !clock.Now().Before(start.Add(calls[0].delay)) {
+			c := calls[0]
+			calls = calls[1:]
+			waiting[FloorDir{c.floor, c.dir}] = append(waiting[FloorDir{c.floor, c.dir}], c)
+			SendPickup(e, Pickup{Floor: c.floor, Dir: c.dir, Done: chPickups})
+		}
+		if next == nil && len(calls) > 0 {
+			next = clock.After(start.Add(calls[0].delay).Sub(clock.Now()))
+		}
+
+		p.Idle(next, deadline)
+		select {
+		case <-next:
+			next = nil
+		case a := <-e.Arrivals():
+			// The car emits its doors OPENING before it sends the Arrivals of the cycle.
+			if n := len(stops); n == 0 || logs.doorCycles() != cycles {
+				cycles = logs.doorCycles()
+				stop := scenarioStop{at: clock.Now(), floor: a.Floor}
+				if n > 0 && stops[n-1].floor == a.Floor {
+					stop.pickedUp = stops[n-1].pickedUp // The doors reopened: check where the car goes after.
+				} else if n > 0 && sc.policy == "collective" {
+					// Those who boarded here already were not aboard as the car came.
+					before := make(map[Floor]int)
+					for f, k := range aboard {
+						before[f] = k - boarded[a.Floor][f]
+					}
+					if err := checkCommitment(stops[n-1], a.Floor, waiting, before); err != nil {
+						return fmt.Errorf("%v; stops %v", err, stops)
+					}
+				}
+				stops = append(stops, stop)
+			}
+			stop := &stops[len(stops)-1]
+			stop.dirs = append(stop.dirs, a.Dir)
+			fd := FloorDir{a.Floor, a.Dir}
+			if a.Alighted > 0 {
+				stop.useful = true
+			}
+			if a.Dir != IDLE && ((len(waiting[fd]) > 0 && !credited[fd]) || uncredited[fd]) {
+				if uncredited[fd] {
+					delete(uncredited, fd)
+					delete(boarded, fd.floor)
+				} else {
+					credited[fd] = true
+				}
+				stop.useful = true
+				stop.pickedUp = append(stop.pickedUp, a.Dir)
+				if sc.policy == "collective" {
+					prev := scenarioStop{at: start, floor: sc.start.floor}
+					if n := len(stops); n > 1 {
+						prev = stops[n-2]
+					}
+					if err := checkTurn(prev, *stop, a.Dir, start, waiting); err != nil {
+						return fmt.Errorf("%v; stops %v", err, stops)
+					}
+				}
+			}
+		case a := <-chPickups:
+			fd := FloorDir{a.Floor, a.Dir}
+			if a.Outcome != Served {
+				return fmt.Errorf("pickup %s %s was %s", a.Floor, a.Dir, a.Outcome)
+			}
+			for _, c := range waiting[fd] {
+				SendDropoff(e, Dropoff{Floor: c.dest, Done: chDropoffs})
+				aboard[c.dest]++
+				if !credited[fd] {
+					if boarded[fd.floor] == nil {
+						boarded[fd.floor] = make(map[Floor]int)
+					}
+					boarded[fd.floor][c.dest]++
+				}
+			}
+			if len(waiting[fd]) == 0 {
+				return fmt.Errorf("pickup arrival at %s %s, but nobody called", a.Floor, a.Dir)
+			}
+			delete(waiting, fd)
+			if credited[fd] {
+				delete(credited, fd)
+			} else {
+				uncredited[fd] = true
+			}
+		case a := <-chDropoffs:
+			if a.Outcome != Served || aboard[a.Floor] == 0 {
+				return fmt.Errorf("dropoff %s at %s, with %d passengers for there", a.Outcome, a.Floor, aboard[a.Floor])
+			}
+			aboard[a.Floor]--
+			pending--
+		case <-deadline:
+			return fmt.Errorf("after %v, %d passengers have not arrived (%d waiting, %d aboard); stops %v",
+				clock.Now().Sub(start), pending, len(waiting), pending-len(waiting), stops)
+		}
+		pending = len(calls)
+		for _, cs := range waiting {
+			pending += len(cs)
+		}
+		for _, n := range aboard {
+			pending += n
+		}
+	}
+
+	for i, stop := range stops {
+		if !stop.useful && sc.policy == "collective" {
+			return fmt.Errorf("stop %d at %s %v let nobody out or in; stops %v", i, stop.floor, stop.dirs, stops)
+		}
+		if i > 0 && !stops[i-1].useful && stops[i-1].floor == stop.floor {
+			return fmt.Errorf("stop %d at %s: the car turned on its second door cycle; stops %v", i, stop.floor, stops)
+		}
+	}
+	if logs.warned() {
+		return fmt.Errorf("the car logged a warning")
+	}
+	return nil
+}
+
+// Checks rule 4 (see elevator.go) for CollectiveSelective, as the car makes a pickup the other way from its
+// travel: when it left its previous stop, it knew of nothing further on (or it would have passed this floor).
+func checkTurn(prev, stop scenarioStop, dir Direction, start time.Time, waiting map[FloorDir][]scenarioCall) error {
+	travel := prev.floor.DirectionTo(stop.floor)
+	if travel == IDLE || travel == dir {
+		return nil
+	}
+	beyond := func(f Floor) bool { return stop.floor.DirectionTo(f) == travel }
+	for fd, calls := range waiting {
+		for _, c := range calls {
+			if beyond(fd.floor) && !start.Add(c.delay).After(prev.at) {
+				return fmt.Errorf("the car turned %s at %s, though it knew of the call %v further on", dir, stop.floor, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks rules 1 and 5 (see elevator.go) for CollectiveSelective, as the car stops at next: if it made a pickup
+// at its previous stop, and had anything to do further in the pickup's direction, next is further that way.
+func checkCommitment(prev scenarioStop, next Floor, waiting map[FloorDir][]scenarioCall, aboard map[Floor]int) error {
+	for _, dir := range prev.pickedUp {
+		beyond := func(f Floor) bool { return prev.floor.DirectionTo(f) == dir }
+		ahead := false
+		for fd := range waiting {
+			ahead = ahead || beyond(fd.floor)
+		}
+		for f, n := range aboard {
+			ahead = ahead || (n > 0 && beyond(f))
+		}
+		if ahead && !beyond(next) {
+			return fmt.Errorf("after the pickup at %s %s, the car went to %s, though it had more to do %s", prev.floor, dir, next, dir)
+		}
+	}
+	return nil
+}
+
+// The tallest building the direction scenarios run in; with -short, 3 floors.
+const scenarioMaxFloors = 4
+
+// Runs the direction scenarios for each StopPolicy on buildings of 2 to scenarioMaxFloors floors, in a subtest
+// per policy, building and start state. The car logs to log's output, which this redirects.
+func TestDirectionScenarios(t *testing.T) {
+	maxFloors := scenarioMaxFloors
+	if testing.Short() {
+		maxFloors = 3
+	}
+	out := log.Writer()
+	defer log.SetOutput(out)
+	for _, policy := range StopPolicyNames {
+		for numFloors := 2; numFloors <= maxFloors; numFloors++ {
+			// The scenarios of a start state are consecutive.
+			var byStart [][]scenario
+			for _, sc := range directionScenarios(numFloors, policy) {
+				if n := len(byStart); n == 0 || byStart[n-1][0].start != sc.start {
+					byStart = append(byStart, nil)
+				}
+				byStart[len(byStart)-1] = append(byStart[len(byStart)-1], sc)
+			}
+			for _, scenarios := range byStart {
+				name := fmt.Sprintf("%s/%d floors/%s", policy, numFloors, scenarios[0].start)
+				t.Run(name, func(t *testing.T) {
+					for _, sc := range scenarios {
+						logs := &scenarioLog{}
+						log.SetOutput(logs)
+						if err := runScenario(sc, logs); err != nil {
+							t.Fatalf("%v: %v", sc, err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The record of one Passenger's trip.
+type Journey struct {
+	Passenger  int
+	Start      lift.Floor
+	Dest       lift.Floor
+	Persons    int
+	Car        int       // The elevator which carried the passenger, or -1 if none.
+	Called     time.Time // When the passenger first requested a pickup.
+	PickedUp   time.Time // When the elevator they boarded arrived (Pickup Arrival).
+	DroppedOff time.Time // When they arrived at Dest (Dropoff Arrival).
+	Refusals   int       // How often they had to step out again (car full, or not going to Dest).
+	Cancelled  bool      // The System was closed (or the run stopped) before they arrived. DroppedOff (and maybe PickedUp) is zero.
+	Oversized  bool      // They gave up: there were too many of them for any car (see lift.Oversized). PickedUp and DroppedOff are zero.
+}
+
+func (j Journey) Wait() time.Duration  { return j.PickedUp.Sub(j.Called) }
+func (j Journey) Ride() time.Duration  { return j.DroppedOff.Sub(j.PickedUp) }
+func (j Journey) Total() time.Duration { return j.DroppedOff.Sub(j.Called) } // Time to destination.
+
+// Collects Journeys. Safe for use by many Passengers at once.
+type Journeys struct {
+	mu       sync.Mutex
+	journeys []Journey
+}
+
+func (js *Journeys) Add(j Journey) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.journeys = append(js.journeys, j)
+}
+
+// Returns the Journeys so far, ordered by passenger.
+func (js *Journeys) All() []Journey {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	all := make([]Journey, len(js.journeys))
+	copy(all, js.journeys)
+	sort.Slice(all, func(i, k int) bool { return all[i].Passenger < all[k].Passenger })
+	return all
+}
+
+// Summary statistics of wait, ride and journey times.
+type Report struct {
+	Passengers int
+	Refusals   int
+	Cancelled  int // Passengers who did not arrive. Their journeys are not in the Stats.
+	Oversized  int // Groups too large for a car, which gave up. Nor are theirs.
+	Wait       Stats
+	Ride       Stats
+	Total      Stats
+}
+
+// Returns the Report of the journeys which used an elevator (start != dest).
+func (js *Journeys) Report() Report {
+	var r Report
+	var wait, ride, total []time.Duration
+	for _, j := range js.All() {
+		if j.Start == j.Dest {
+			continue
+		}
+		if j.Cancelled {
+			r.Cancelled++
+			continue
+		}
+		if j.Oversized {
+			r.Oversized++
+			continue
+		}
+		r.Passengers++
+		r.Refusals += j.Refusals
+		wait = append(wait, j.Wait())
+		ride = append(ride, j.Ride())
+		total = append(total, j.Total())
+	}
+	r.Wait, r.Ride, r.Total = Summarize(wait), Summarize(ride), Summarize(total)
+	return r
+}
+
+// Prints each Journey, one per line.
+func (js *Journeys) Print(w io.Writer) {
+	fmt.Fprintf(w, "%-9s %5s %5s %3s %10s %10s %10s\n", "passenger", "start", "dest", "car", "wait", "ride", "journey")
+	for _, j := range js.All() {
+		fmt.Fprintf(w, "%-9d %5s %5s %3d %10v %10v %10v\n", j.Passenger, j.Start, j.Dest, j.Car,
+			j.Wait().Round(time.Millisecond), j.Ride().Round(time.Millisecond), j.Total().Round(time.Millisecond))
+	}
+}
+
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d passengers, %d refusals\n", r.Passengers, r.Refusals)
+	if r.Cancelled > 0 {
+		fmt.Fprintf(w, "%d passengers cancelled\n", r.Cancelled)
+	}
+	if r.Oversized > 0 {
+		fmt.Fprintf(w, "%d groups gave up: too large for a car\n", r.Oversized)
+	}
+	fmt.Fprintf(w, "%-8s %10s %10s %10s %10s %10s\n", "", "mean", "p50", "p90", "p95", "max")
+	for _, row := range []struct {
+		name  string
+		stats Stats
+	}{{"wait", r.Wait}, {"ride", r.Ride}, {"journey", r.Total}} {
+		s := row.stats
+		fmt.Fprintf(w, "%-8s %10v %10v %10v %10v %10v\n", row.name, s.Mean.Round(time.Millisecond), s.P50.Round(time.Millisecond),
+			s.P90.Round(time.Millisecond), s.P95.Round(time.Millisecond), s.Max.Round(time.Millisecond))
+	}
+}
+
+type Stats struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P95   time.Duration
+	Max   time.Duration
+}
+
+// Returns the Stats of the durations. Percentiles use the nearest-rank method.
+func Summarize(durations []time.Duration) Stats {
+	if len(durations) == 0 {
+		return Stats{}
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, k int) bool { return sorted[i] < sorted[k] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return Stats{Count: len(sorted), Mean: sum / time.Duration(len(sorted)),
+		P50: Percentile(sorted, 50), P90: Percentile(sorted, 90), P95: Percentile(sorted, 95), Max: sorted[len(sorted)-1]}
+}
+
+// Returns the p-th percentile of the sorted durations (nearest rank).
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,137 @@
+package sim
+
+import (
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"log"
+)
+
+// Passenger is group of people who requests a pickup, boards, and requests a dropoff.
+type Passenger struct {
+	Id      int
+	Start   lift.Floor // Ick: naming start v. end, origin vs. dest?
+	Dest    lift.Floor
+	Persons int // How many people travel together. Zero means one.
+}
+
+// Rides from Start to Dest, and returns the Journey. Blocks until the Passenger arrives, or gives up as stop is
+// closed. part is the Passenger's Participant of the clock (see lift.Join).
+func (p *Passenger) Run(requestor lift.Requestor, clock lift.Clock, part *lift.Participant, stop <-chan struct{}) Journey {
+	j := Journey{Passenger: p.Id, Start: p.Start, Dest: p.Dest, Persons: p.Persons, Car: -1, Called: clock.Now()}
+	if p.Start == p.Dest {
+		log.Printf("Passenger-%d skipping elevator: start %s == dest %s\n", p.Id, p.Start, p.Dest)
+		j.PickedUp, j.DroppedOff = j.Called, j.Called
+		return j
+	}
+
+	dir := p.Start.DirectionTo(p.Dest)
+	unserved := make(map[int]bool) // Elevators which do not go to our dest.
+	tooSmall := make(map[int]bool) // Elevators we do not fit in. The System sends one we fit, if there is one.
+	for {
+		// Request pickup and wait.
+		chArrival := make(chan lift.Arrival, 1) // Buffered: the System need not wait for us, should we give up.
+		part.Listen(chArrival)
+		pickup := lift.Pickup{Floor: p.Start, Dir: dir, Done: chArrival, Dests: []lift.Floor{p.Dest}, Persons: p.Persons,
+			Listener: p.listener()}
+		log.Printf("Passenger-%d requesting pickup %s %s\n", p.Id, p.Start, dir)
+		if lift.SendPickup(requestor, pickup) != nil {
+			return p.cancelled(j)
+		}
+		log.Printf("Passenger-%d waiting for pickup %s %s on channel %v\n", p.Id, p.Start, dir, chArrival)
+
+		// Wait for arrival.
+		part.Idle()
+		var a lift.Arrival
+		select {
+		case a = <-chArrival:
+		case <-stop:
+			return p.stopped(j)
+		}
+		if a.Outcome == lift.Cancelled {
+			return p.cancelled(j)
+		}
+		if a.Outcome == lift.Oversized {
+			log.Printf("Passenger-%d gave up: %d persons fit in no car\n", p.Id, p.Persons)
+			j.Oversized = true
+			return j
+		}
+		if a.Floor != p.Start {
+			panic(fmt.Sprintf("Waiting at %s, but pickup arrival says %s", p.Start, a.Floor))
+		}
+		if a.Dir != dir {
+			panic(fmt.Sprintf("Waiting for %s lift, but pickup arrival says direction is %s", dir, a.Dir))
+		}
+		if unserved[a.Conveyor.Id()] {
+			log.Printf("Passenger-%d lets Elevator-%d go: it does not serve %s\n", p.Id, a.Conveyor.Id(), p.Dest)
+			part.Sleep(lift.TimeServiceFloor) // Call again once it has left.
+			continue
+		}
+		if tooSmall[a.Conveyor.Id()] {
+			log.Printf("Passenger-%d lets Elevator-%d go: %d persons do not fit\n", p.Id, a.Conveyor.Id(), p.Persons)
+			part.Sleep(lift.TimeServiceFloor)
+			continue
+		}
+		j.PickedUp = clock.Now()
+		j.Car = a.Conveyor.Id()
+
+		// Board and press button.
+		chArrival = make(chan lift.Arrival, 1) // For safety, we make a new channel for dropoff than for pickup.
+		part.Listen(chArrival)
+		log.Printf("Passenger-%d boarded Elevator-%d at %s %s\n", p.Id, a.Conveyor.Id(), p.Start, dir)
+		part.Sleep(lift.TimeSelectDropoff) // Less than the door dwell time, see lift.DefaultDoorTimes.
+		log.Printf("Passenger-%d requesting dropoff %s\n", p.Id, p.Dest)
+		dropoff := lift.Dropoff{Floor: p.Dest, Persons: p.Persons, Done: chArrival, Listener: p.listener()}
+		if lift.SendDropoff(a.Conveyor, dropoff) != nil {
+			return p.cancelled(j)
+		}
+		log.Printf("Passenger-%d riding to floor %s, waiting for dropoff on channel %v\n", p.Id, p.Dest, chArrival)
+
+		// Wait for arrival
+		part.Idle()
+		select {
+		case a = <-chArrival:
+		case <-stop:
+			return p.stopped(j)
+		}
+		switch a.Outcome {
+		case lift.Cancelled:
+			return p.cancelled(j)
+		case lift.Refused:
+			log.Printf("Passenger-%d refused by full Elevator-%d at %s\n", p.Id, a.Conveyor.Id(), p.Start)
+			j.Refusals++
+			continue
+		case lift.Oversized:
+			log.Printf("Passenger-%d stepped out of Elevator-%d: %d persons do not fit\n", p.Id, a.Conveyor.Id(), p.Persons)
+			tooSmall[a.Conveyor.Id()] = true
+			j.Refusals++
+			continue
+		case lift.Unserved:
+			log.Printf("Passenger-%d stepped out of Elevator-%d: it does not serve %s\n", p.Id, a.Conveyor.Id(), p.Dest)
+			unserved[a.Conveyor.Id()] = true
+			j.Refusals++
+			continue
+		}
+		if a.Floor != p.Dest {
+			panic(fmt.Sprintf("Passenger-%d waiting to arrive at at %s, but dropoff arrival says %s", p.Id, p.Dest, a.Floor))
+		}
+		break
+	}
+	j.DroppedOff = clock.Now()
+	log.Printf("Passenger-%d arrived at destination floor %s\n", p.Id, p.Dest)
+	return j
+}
+
+func (p *Passenger) cancelled(j Journey) Journey {
+	log.Printf("Passenger-%d gave up: the elevators were shut down\n", p.Id)
+	j.Cancelled = true
+	return j
+}
+
+func (p *Passenger) stopped(j Journey) Journey {
+	log.Printf("Passenger-%d gave up: the run was stopped\n", p.Id)
+	j.Cancelled = true
+	return j
+}
+
+// Identifies our requests' Done channels in a lift.Snapshot.
+func (p *Passenger) listener() string { return fmt.Sprintf("passenger-%d", p.Id) }
@@ -0,0 +1,62 @@
+package sim
+
+import (
+	"github.com/delliston/mygo/lift"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Call is the appearance of a Passenger (group) at its Start floor, At some time after the start of a run.
+type Call struct {
+	At      time.Duration
+	Start   lift.Floor
+	Dest    lift.Floor
+	Persons int // Zero means one.
+}
+
+// Sorts calls by At, keeping the order of simultaneous calls.
+func SortCalls(calls []Call) {
+	sort.SliceStable(calls, func(i, k int) bool { return calls[i].At < calls[k].At })
+}
+
+// Creates one Passenger per Call, at the Call's time (relative to now), and waits until all have arrived, or stop
+// is closed: then it creates no more, and those on their way give up.
+// Passengers are numbered from 1, in the order of calls, which must be sorted.
+func Run(requestor lift.Requestor, clock lift.Clock, calls []Call, stop <-chan struct{}) *Journeys {
+	journeys := &Journeys{}
+	wgPass := sync.WaitGroup{}
+	part := lift.Join(clock)
+	defer part.Leave()
+	start := clock.Now()
+create:
+	for i, c := range calls {
+		if wait := start.Add(c.At).Sub(clock.Now()); wait > 0 {
+			timer := clock.After(wait)
+			part.Idle(timer)
+			select {
+			case <-timer:
+			case <-stop:
+			}
+		}
+		select {
+		case <-stop:
+			log.Printf("Stopped with %d passengers still to come\n", len(calls)-i)
+			break create
+		default:
+		}
+		p := &Passenger{Id: i + 1, Start: c.Start, Dest: c.Dest, Persons: c.Persons}
+		log.Printf("Passenger-%d created with start %s, dest %s\n", p.Id, p.Start, p.Dest)
+		wgPass.Add(1)
+		pPart := lift.Join(clock) // Before it starts: the clock must not move on meanwhile.
+		go func() {
+			defer pPart.Leave()
+			journeys.Add(p.Run(requestor, clock, pPart, stop))
+			wgPass.Done()
+		}()
+	}
+	part.Idle()
+	wgPass.Wait() // Waits until all passengers complete.
+	return journeys
+}
@@ -0,0 +1,371 @@
+package lift
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// The state of a System and its cars at one moment, as written in JSON: every car (its position, run, doors,
+// load and requests) and every outstanding hall call. See System.Snapshot and NewSystemFromSnapshot.
+// Listeners (the Done channels of Pickups and Dropoffs) are recorded by the id their client gave them.
+// Faults (see Fault) are not recorded: the System resumes clear of them. Each car's CarMode is.
+type Snapshot struct {
+	Time      time.Time          `json:"time"` // When it was taken, by the System's Clock.
+	Floors    int                `json:"floors"`
+	LastCall  int64              `json:"lastCall"` // The id of the latest hall call.
+	Aging     AgingStats         `json:"aging"`
+	HallCalls []HallCallSnapshot `json:"hallCalls"`
+	Cars      []CarSnapshot      `json:"cars"` // In id order.
+}
+
+// An outstanding hall call, in a Snapshot.
+type HallCallSnapshot struct {
+	Floor     Floor     `json:"floor"`
+	Dir       Direction `json:"dir"`
+	Call      int64     `json:"call"`
+	Since     time.Time `json:"since"`
+	Car       int       `json:"car"` // The car it is dispatched to, or -1 if none could take it yet.
+	Escalated bool      `json:"escalated,omitempty"`
+	Dests     []Floor   `json:"dests,omitempty"`     // As the passengers gave them; none if any did not.
+	Persons   int       `json:"persons,omitempty"`   // The largest group waiting, if any said (see Pickup.Persons).
+	Listeners []string  `json:"listeners,omitempty"` // See Pickup.Listener.
+}
+
+// An Elevator, in a Snapshot.
+type CarSnapshot struct {
+	Id          int                `json:"id"`
+	Floor       Floor              `json:"floor"` // As CarStatus.
+	Dest        Floor              `json:"dest"`
+	Dir         Direction          `json:"dir"`
+	Drive       DriveSnapshot      `json:"drive"`
+	Doors       DoorState          `json:"doors"`
+	DoorsUntil  time.Time          `json:"doorsUntil"` // When the doors' current state ends, unless they are CLOSED.
+	Persons     int                `json:"persons"`    // Aboard.
+	Kg          int                `json:"kg"`
+	Alighting   []AlightingLoad    `json:"alighting,omitempty"`
+	Dropoffs    []Floor            `json:"dropoffs,omitempty"`
+	PickupsUp   []Floor            `json:"pickupsUp,omitempty"`
+	PickupsDown []Floor            `json:"pickupsDown,omitempty"`
+	Pickups     []PickupSnapshot   `json:"pickups,omitempty"` // When each of PickupsUp and PickupsDown was called.
+	Listeners   []ListenerSnapshot `json:"listeners,omitempty"`
+	Mode        CarMode            `json:"mode,omitempty"` // Empty means ModeNormal.
+}
+
+// The passengers aboard a car who get out at Floor.
+type AlightingLoad struct {
+	Floor   Floor `json:"floor"`
+	Persons int   `json:"persons"`
+	Kg      int   `json:"kg"`
+}
+
+// A pickup a car holds, in a CarSnapshot.
+type PickupSnapshot struct {
+	Floor Floor     `json:"floor"`
+	Dir   Direction `json:"dir"`
+	Since time.Time `json:"since"`
+	Aged  bool      `json:"aged,omitempty"`
+	Call  int64     `json:"call,omitempty"`
+}
+
+// The ids of the listeners waiting for an Arrival at Floor in Dir (IDLE for Dropoffs).
+type ListenerSnapshot struct {
+	Floor Floor     `json:"floor"`
+	Dir   Direction `json:"dir"`
+	Ids   []string  `json:"ids"`
+}
+
+// A car's drive, in a CarSnapshot. While moving (Dir is not IDLE), it is part way through a run from Origin to
+// Dest, which Started at the given time.
+type DriveSnapshot struct {
+	Floor   Floor                `json:"floor"`
+	Dest    Floor                `json:"dest"`
+	Dir     Direction            `json:"dir"`
+	Origin  Floor                `json:"origin"`
+	Started time.Time            `json:"started"`
+	Pending []NotificationRecord `json:"pending,omitempty"` // Floors passed or stopped at, which the car has not yet been told of.
+}
+
+// A DriverStopNotification, in a DriveSnapshot.
+type NotificationRecord struct {
+	Floor    Floor `json:"floor"`
+	Stopping bool  `json:"stopping,omitempty"`
+}
+
+// Returns the state of the System and its cars, for NewSystemFromSnapshot; or nil if the System is closed.
+// Requests the System and a car have not yet passed to each other (e.g. an Arrival on its way to the System)
+// are not recorded. Safe to call from any goroutine.
+func (s *System) Snapshot() *Snapshot {
+	chReply := make(chan *Snapshot, 1)
+	expect(s.chSnapshots)
+	select {
+	case s.chSnapshots <- chReply:
+		return <-chReply
+	case <-s.life.quit:
+		unexpect(s.chSnapshots)
+		return nil
+	}
+}
+
+func (s *System) snapshot() *Snapshot {
+	snap := &Snapshot{Time: s.clock.Now(), Floors: int(s.pickupsUp.maxFloor) + 1, LastCall: s.lastCall,
+		Aging: s.Aging(), HallCalls: []HallCallSnapshot{}}
+	for _, fd := range s.sortedCalls() {
+		call := s.calls[fd]
+		hall := HallCallSnapshot{Floor: fd.floor, Dir: fd.dir, Call: call.id, Since: call.since, Car: -1,
+			Escalated: call.escalated, Dests: call.pickup(fd).Dests, Persons: call.persons, Listeners: s.waiters.ids(fd)}
+		if call.car != nil {
+			hall.Car = call.car.Id()
+		}
+		snap.HallCalls = append(snap.HallCalls, hall)
+	}
+	chReply := make(chan CarSnapshot)
+	for _, e := range s.elevators {
+		expect(e.SnapshotQueries())
+		e.SnapshotQueries() <- SnapshotQuery{chReply}
+		snap.Cars = append(snap.Cars, <-chReply)
+	}
+	return snap
+}
+
+// Creates a System (as NewSystem) in the state of the Snapshot, which must have been taken of a System with the
+// same floors and cars. Its times are moved on to the clock's: a call which had waited a minute when the
+// snapshot was taken has waited a minute now, and a moving car carries on from where it was.
+// listeners returns the Done channel for each Listener id, or nil if it is gone; listeners may be nil.
+// A hall call whose car no longer held it (e.g. its Arrival was on the way to the System) is dispatched again.
+func NewSystemFromSnapshot(snap *Snapshot, numFloors int, cars []CarSpec, dispatcher Dispatcher, clock Clock, events EventSink,
+	journal *Journal, listeners func(id string) chan<- Arrival) (*System, error) {
+	if err := snap.validate(numFloors, len(cars)); err != nil {
+		return nil, err
+	}
+	if listeners == nil {
+		listeners = func(string) chan<- Arrival { return nil }
+	}
+	s := makeSystem(numFloors, cars, dispatcher, clock, events, journal)
+	shift := clock.Now().Sub(snap.Time)
+	for i, cs := range snap.Cars {
+		s.elevators[i].(*Elevator).restore(cs, shift, listeners)
+	}
+	s.lastCall, s.aging = snap.LastCall, snap.Aging
+	for _, hall := range snap.HallCalls {
+		fd := FloorDir{hall.Floor, hall.Dir}
+		call := &hallCall{id: hall.Call, since: hall.Since.Add(shift), escalated: hall.Escalated, dests: hall.Dests,
+			anyDest: len(hall.Dests) == 0, persons: hall.Persons}
+		s.calls[fd] = call
+		s.pickups(fd.dir).set(fd.floor)
+		for _, id := range hall.Listeners {
+			if id != "" {
+				s.waiters.addPickupListener(Pickup{Floor: fd.floor, Dir: fd.dir, Done: listeners(id), Listener: id})
+			}
+		}
+		if hall.Car >= 0 && snap.Cars[hall.Car].holds(fd) {
+			call.car = s.elevators[hall.Car]
+			continue
+		}
+		if hall.Car >= 0 {
+			log.Printf("System: Elevator-%d no longer has %s %s, dispatching it again\n", hall.Car, fd.floor, fd.dir)
+		}
+		s.unassigned = append(s.unassigned, fd)
+	}
+	s.start()
+	return s, nil
+}
+
+// Checks that the Snapshot fits a System of numFloors and numCars, that its floors are in range, and that its
+// hall calls go UP or DOWN.
+func (snap *Snapshot) validate(numFloors, numCars int) error {
+	if snap.Floors != numFloors {
+		return fmt.Errorf("snapshot has %d floors, but the System has %d", snap.Floors, numFloors)
+	}
+	if len(snap.Cars) != numCars {
+		return fmt.Errorf("snapshot has %d cars, but the System has %d", len(snap.Cars), numCars)
+	}
+	var bad []Floor
+	check := func(floors ...Floor) {
+		for _, f := range floors {
+			if f < 0 || int(f) >= snap.Floors {
+				bad = append(bad, f)
+			}
+		}
+	}
+	for _, hall := range snap.HallCalls {
+		check(hall.Floor)
+		check(hall.Dests...)
+		if hall.Dir != UP && hall.Dir != DOWN {
+			return fmt.Errorf("hall call %d at %s goes neither UP nor DOWN", hall.Call, hall.Floor)
+		}
+		if hall.Car < -1 || hall.Car >= numCars {
+			return fmt.Errorf("hall call %d is dispatched to car %d, but there are %d cars", hall.Call, hall.Car, numCars)
+		}
+	}
+	for i, cs := range snap.Cars {
+		if cs.Id != i {
+			return fmt.Errorf("car %d of the snapshot has id %d", i, cs.Id)
+		}
+		if cs.Mode != "" {
+			if err := cs.Mode.Validate(); err != nil {
+				return fmt.Errorf("car %d: %v", i, err)
+			}
+		}
+		check(cs.Floor, cs.Dest, cs.Drive.Floor, cs.Drive.Dest, cs.Drive.Origin)
+		check(cs.Dropoffs...)
+		check(cs.PickupsUp...)
+		check(cs.PickupsDown...)
+		for _, a := range cs.Alighting {
+			check(a.Floor)
+		}
+		for _, p := range cs.Pickups {
+			check(p.Floor)
+		}
+		for _, l := range cs.Listeners {
+			check(l.Floor)
+		}
+		for _, n := range cs.Drive.Pending {
+			check(n.Floor)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("snapshot of %d floors has floors %v", snap.Floors, bad)
+	}
+	return nil
+}
+
+// Returns true if the car has the pickup.
+func (cs CarSnapshot) holds(fd FloorDir) bool {
+	floors := cs.PickupsUp
+	if fd.dir == DOWN {
+		floors = cs.PickupsDown
+	}
+	for _, f := range floors {
+		if f == fd.floor {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Elevator) snapshot() CarSnapshot {
+	chDrive := make(chan DriveSnapshot)
+	expect(e.drive.chSnapshot)
+	e.drive.chSnapshot <- chDrive
+	cs := CarSnapshot{Id: e.id, Floor: e.floor, Dest: e.dest, Dir: e.dir, Drive: <-chDrive, Doors: e.door,
+		Persons: e.load.persons, Kg: e.load.kg, Dropoffs: e.dropoffs.floors(), PickupsUp: e.pickupsUp.floors(),
+		PickupsDown: e.pickupsDown.floors()}
+	if e.doorsBusy() {
+		cs.DoorsUntil = e.doorDeadline
+	}
+	for f, l := range e.alighting {
+		if l != (load{}) {
+			cs.Alighting = append(cs.Alighting, AlightingLoad{Floor(f), l.persons, l.kg})
+		}
+	}
+	for _, dir := range []Direction{UP, DOWN} {
+		for _, f := range e.pickups(dir).floors() {
+			fd := FloorDir{f, dir}
+			since := e.pickupSince[fd]
+			cs.Pickups = append(cs.Pickups, PickupSnapshot{Floor: f, Dir: dir, Since: since, Aged: since.IsZero(),
+				Call: e.pickupCalls[fd]})
+		}
+	}
+	cs.Listeners = e.waiters.snapshot()
+	if e.mode != ModeNormal {
+		cs.Mode = e.mode
+	}
+	return cs
+}
+
+// Puts the Elevator (not yet started) in the state of the CarSnapshot, whose times are shift behind our clock.
+func (e *Elevator) restore(cs CarSnapshot, shift time.Duration, listeners func(id string) chan<- Arrival) {
+	e.floor, e.dest, e.dir = cs.Floor, cs.Dest, cs.Dir
+	e.drive.restore(cs.Drive, shift)
+	e.runOrigin = cs.Drive.Origin // We time the run from the next floor it reaches: see checkDrive.
+	if cs.Doors != DoorsClosed {
+		e.door, e.doorDeadline = cs.Doors, cs.DoorsUntil.Add(shift)
+		e.doorTimer = e.clock.After(e.doorDeadline.Sub(e.clock.Now()))
+	}
+	e.load = load{cs.Persons, cs.Kg}
+	for _, a := range cs.Alighting {
+		e.alighting[a.Floor] = load{a.Persons, a.Kg}
+	}
+	for _, f := range cs.Dropoffs {
+		e.dropoffs.set(f)
+	}
+	for _, f := range cs.PickupsUp {
+		e.pickupsUp.set(f)
+	}
+	for _, f := range cs.PickupsDown {
+		e.pickupsDown.set(f)
+	}
+	for _, p := range cs.Pickups {
+		fd := FloorDir{p.Floor, p.Dir}
+		e.pickupSince[fd] = time.Time{}
+		if !p.Aged {
+			e.pickupSince[fd] = p.Since.Add(shift)
+		}
+		if p.Call != 0 {
+			e.pickupCalls[fd] = p.Call
+		}
+	}
+	e.waiters.restore(cs.Listeners, listeners)
+	if cs.Mode != "" {
+		e.mode = cs.Mode
+	}
+}
+
+func (d *elevatorDriver) snapshot() DriveSnapshot {
+	ds := DriveSnapshot{Floor: d.floor, Dest: d.dest, Dir: d.dir, Origin: d.origin, Started: d.started}
+	for _, n := range d.pending {
+		ds.Pending = append(ds.Pending, NotificationRecord{n.floor, n.stopping})
+	}
+	return ds
+}
+
+// Puts the driver (not yet started) in the state of the DriveSnapshot. If it was moving, it carries on with
+// the same run, which started shift later than the snapshot says.
+func (d *elevatorDriver) restore(ds DriveSnapshot, shift time.Duration) {
+	d.floor, d.dest, d.dir, d.origin = ds.Floor, ds.Dest, ds.Dir, ds.Origin
+	if d.dir != IDLE {
+		d.started = ds.Started.Add(shift)
+		d.run = d.runTo(d.dest)
+	}
+	for _, n := range ds.Pending {
+		d.pending = append(d.pending, DriverStopNotification{n.Floor, n.Stopping})
+	}
+}
+
+// Returns the ids of the listeners at floorDir.
+func (m ArrivalListeners) ids(floorDir FloorDir) []string {
+	var ids []string
+	for _, l := range m[floorDir] {
+		ids = append(ids, l.id)
+	}
+	return ids
+}
+
+// Returns the ids of the listeners, in floor order.
+func (m ArrivalListeners) snapshot() []ListenerSnapshot {
+	var floorDirs []FloorDir
+	for fd, listeners := range m {
+		if len(listeners) > 0 {
+			floorDirs = append(floorDirs, fd)
+		}
+	}
+	sortFloorDirs(floorDirs)
+	var snaps []ListenerSnapshot
+	for _, fd := range floorDirs {
+		snaps = append(snaps, ListenerSnapshot{Floor: fd.floor, Dir: fd.dir, Ids: m.ids(fd)})
+	}
+	return snaps
+}
+
+// Adds the listeners of the snapshot, whose channels the function returns. Those without an id (or whose
+// channel is gone) are dropped: nobody is waiting for them now.
+func (m ArrivalListeners) restore(snaps []ListenerSnapshot, listeners func(id string) chan<- Arrival) {
+	for _, ls := range snaps {
+		for _, id := range ls.Ids {
+			if id != "" {
+				m._addListener(FloorDir{ls.Floor, ls.Dir}, arrivalListener{ch: listeners(id), id: id})
+			}
+		}
+	}
+}
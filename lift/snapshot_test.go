@@ -0,0 +1,92 @@
+package lift
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// A System restored from a Snapshot (through JSON) has the state it was taken of: the same snapshot again.
+func TestSnapshotRoundTrip(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	clock.Pause() // The cars stand where they are.
+	specs := DefaultCarSpecs(2)
+	s := NewSystem(6, specs, firstDispatcher{}, clock, nil, nil)
+	defer s.Close()
+
+	if err := s.SetCarMode(1, ModeOutOfService); err != nil {
+		t.Fatal(err)
+	}
+	chUp, chDown := make(chan Arrival, 1), make(chan Arrival, 1)
+	for _, pickup := range []Pickup{
+		{Floor: 3, Dir: UP, Persons: 3, Dests: []Floor{5}, Done: chUp, Listener: "up"},
+		{Floor: 4, Dir: DOWN, Done: chDown, Listener: "down"},
+	} {
+		if err := SendPickup(s, pickup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SendDropoff(s.Conveyors()[0], Dropoff{Floor: 2, Done: make(chan Arrival, 1), Listener: "aboard"}); err != nil {
+		t.Fatal(err)
+	}
+	taken, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(taken, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.HallCalls) != 2 || snap.HallCalls[0].Persons != 3 || len(snap.HallCalls[0].Dests) != 1 {
+		t.Fatalf("the snapshot lost the group at 3 UP, or its dest: %s", taken)
+	}
+
+	restoredClock := NewVirtualClock(snap.Time)
+	defer restoredClock.Stop()
+	restoredClock.Pause()
+	listeners := map[string]chan<- Arrival{"up": chUp, "down": chDown, "aboard": make(chan Arrival, 1)}
+	restored, err := NewSystemFromSnapshot(&snap, 6, specs, firstDispatcher{}, restoredClock, nil, nil,
+		func(id string) chan<- Arrival { return listeners[id] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	again, err := json.Marshal(restored.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(taken) {
+		t.Errorf("the restored System's snapshot differs:\n got %s\nwant %s", again, taken)
+	}
+}
+
+// A Snapshot which does not fit the System, or whose hall calls go nowhere, is rejected.
+func TestSnapshotValidate(t *testing.T) {
+	valid := func() *Snapshot {
+		return &Snapshot{Floors: 5, HallCalls: []HallCallSnapshot{{Floor: 2, Dir: UP, Call: 1, Car: -1}},
+			Cars: []CarSnapshot{{Id: 0}}}
+	}
+	if err := valid().validate(5, 1); err != nil {
+		t.Fatalf("a valid snapshot: %v", err)
+	}
+	for _, c := range []struct {
+		name   string
+		change func(*Snapshot)
+		want   string
+	}{
+		{"floors", func(snap *Snapshot) { snap.Floors = 6 }, "floors"},
+		{"cars", func(snap *Snapshot) { snap.Cars = nil }, "cars"},
+		{"idle hall call", func(snap *Snapshot) { snap.HallCalls[0].Dir = IDLE }, "neither UP nor DOWN"},
+		{"hall call floor", func(snap *Snapshot) { snap.HallCalls[0].Floor = 5 }, "has floors [5]"},
+		{"hall call dest", func(snap *Snapshot) { snap.HallCalls[0].Dests = []Floor{-1} }, "has floors [-1]"},
+		{"hall call car", func(snap *Snapshot) { snap.HallCalls[0].Car = 1 }, "car 1"},
+		{"mode", func(snap *Snapshot) { snap.Cars[0].Mode = "parked" }, "car 0"},
+	} {
+		snap := valid()
+		c.change(snap)
+		if err := snap.validate(5, 1); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want an error about %q", c.name, err, c.want)
+		}
+	}
+}
@@ -1,84 +1,613 @@
 package lift
 
 import (
-	"math/rand"
-	//	"fmt"
+	"fmt"
 	"log"
+	"sort"
+	"sync/atomic"
+	"time"
 )
 
 // The System provisions the elevators (TODO: structs or channels)
 // plus the records of any pickup requests (up or down) at each floor.
+// Each outstanding pickup is dispatched to one elevator only. All Arrivals are routed through the System:
+// when any elevator arrives at a pickup's floor (in its direction), the System notifies the waiting passengers,
+// and cancels the pickup on every other elevator.
 type System struct {
-	elevators []Conveyor
-	// FUTURE: Is it really necessary to track pickups{Up,Down}? Elevators do it already. Seems the System wants to know too.
-	pickupsUp   *FloorSet   // Floors which have outstanding UP requests are true
-	pickupsDown *FloorSet   // Floors which have outstanding DOWN requests are true
-	chPickups   chan Pickup // System receives Pickup Requests from users.
-	//	chArrivals chan Arrival
-	//	waiters ArrivalListenerss  // On arrival at FloorDir, forward Arrival channel to all registered listeners.
-}
-
-func (s *System) Pickups() chan<- Pickup { return s.chPickups }
-func NewSystem(numFloors, numElevators int) *System {
-	elevators := make([]Conveyor, numElevators) // <sigh> In Python, these 4 lines would just be a List Comprehension: [ NewElevator(i, numFloors) for i in range(numFloors) ]
-	for i := 0; i < numElevators; i++ {
-		elevators[i] = NewElevator(i, numFloors)
-	}
-	s := &System{elevators, newFloorSet(numFloors), newFloorSet(numFloors), make(chan Pickup)}
-	go s.mainLoop()
+	elevators   []Conveyor
+	pickupsUp   *FloorSet        // Floors which have outstanding UP requests are true
+	pickupsDown *FloorSet        // Floors which have outstanding DOWN requests are true
+	chPickups   chan Pickup      // System receives Pickup Requests from users.
+	chArrivals  chan Arrival     // System receives the Arrivals of all elevators.
+	chReturns   chan Pickup      // System receives the pickups which elevators hand back.
+	unassigned  []FloorDir       // Hall calls which no elevator could take (e.g., all full). Retried on every arrival.
+	waiters     ArrivalListeners // On arrival at FloorDir, forward Arrival to all registered listeners.
+	dispatcher  Dispatcher       // Chooses which elevator serves each Pickup.
+	watchers    map[chan<- Arrival]bool
+	chWatch     chan watchRequest
+	chHalls     chan chan<- []HallCallStatus
+	chSnapshots chan chan<- *Snapshot
+	chFaults    chan FaultReport // System receives the faults which elevators detect.
+	faults      FaultStats       // Updated atomically: see Faults.
+	chModes     chan modeRequest // System receives the mode changes of SetCarMode.
+	events      *eventLog
+	journal     *Journal
+	life        lifecycle
+
+	// Aging: a hall call which waits longer than its car's MaxWait is escalated. See onAgingTimer.
+	clock         Clock
+	maxWaits      []time.Duration        // CarSpec.MaxWait, by elevator id.
+	capacities    []Capacity             // CarSpec.Capacity, by elevator id.
+	calls         map[FloorDir]*hallCall // The outstanding pickups (as in pickupsUp and pickupsDown).
+	lastCall      int64                  // The id of the latest hallCall.
+	agingTimer    <-chan time.Time       // Fires at agingDeadline. nil if no call can outwait its car.
+	agingDeadline time.Time
+	aging         AgingStats // Updated atomically: see Aging.
+}
+
+// Adds (or removes) a channel which receives every Arrival. See Watch.
+type watchRequest struct {
+	ch    chan<- Arrival
+	watch bool
+}
+
+// An outstanding hall call, as the System dispatched it. Every dispatch of it (again) is made from this record.
+type hallCall struct {
+	id        int64     // For Events.
+	since     time.Time // When the first passenger called.
+	car       Conveyor  // The elevator it was dispatched to. nil while unassigned.
+	escalated bool      // The aging rule has fired for it.
+	dests     []Floor   // Where its passengers are going, as they said (see Pickup.Dests).
+	anyDest   bool      // A passenger did not say: any car will do.
+	persons   int       // The largest group waiting, if any said how many (see Pickup.Persons).
+}
+
+// Adds the passengers of the pickup to the call.
+func (call *hallCall) join(pickup Pickup) {
+	if len(pickup.Dests) == 0 {
+		call.anyDest = true
+	}
+	for _, dest := range pickup.Dests {
+		known := false
+		for _, d := range call.dests {
+			known = known || d == dest
+		}
+		if !known {
+			call.dests = append(call.dests, dest)
+		}
+	}
+	if pickup.Persons > call.persons {
+		call.persons = pickup.Persons
+	}
+}
+
+// Returns the Pickup to dispatch for the call.
+func (call *hallCall) pickup(floorDir FloorDir) Pickup {
+	pickup := Pickup{Floor: floorDir.floor, Dir: floorDir.dir, Persons: call.persons, Since: call.since, Call: call.id}
+	if !call.anyDest {
+		pickup.Dests = call.dests
+	}
+	return pickup
+}
+
+// How often the aging rule fired: the System escalated a hall call to another car (Escalated), or a car made
+// a pickup ahead of its other requests (AgedPickups). An escalated call usually counts in both.
+type AgingStats struct {
+	Escalated   int64
+	AgedPickups int64
+}
+
+func (s *System) Pickups() chan<- Pickup  { return s.chPickups }
+func (s *System) Closed() <-chan struct{} { return s.life.quit }
+
+// Returns the elevators, in id order. Send Dropoffs to them for passengers who have boarded.
+func (s *System) Conveyors() []Conveyor { return s.elevators }
+
+// Returns the status of every car, in id order; or nil if the System is closed. Safe to call from any goroutine.
+func (s *System) Status() []CarStatus {
+	statuses := make([]CarStatus, len(s.elevators))
+	chReply := make(chan CarStatus, 1)
+	for i, e := range s.elevators {
+		expect(e.StatusQueries())
+		select {
+		case e.StatusQueries() <- StatusQuery{chReply}:
+			statuses[i] = <-chReply
+		case <-s.life.quit:
+			unexpect(e.StatusQueries())
+			return nil
+		}
+	}
+	return statuses
+}
+
+// Sends a copy of every Arrival of every car (dropoffs included) to ch, until unwatch is called. The System
+// does not wait for ch: if it is full, the Arrival is dropped, so ch should be buffered. Safe to call from any
+// goroutine. After Close, ch receives nothing more.
+func (s *System) Watch(ch chan<- Arrival) (unwatch func()) {
+	s.sendWatch(watchRequest{ch, true})
+	return func() { s.sendWatch(watchRequest{ch, false}) }
+}
+
+func (s *System) sendWatch(req watchRequest) {
+	expect(s.chWatch)
+	select {
+	case s.chWatch <- req:
+	case <-s.life.quit:
+		unexpect(s.chWatch)
+	}
+}
+
+// Sends every CarStatus of every car to ch: each car's current one at once, then each change, until unwatch is
+// called. As with Watch, a CarStatus is dropped if ch is full. Safe to call from any goroutine.
+func (s *System) WatchCars(ch chan<- CarStatus) (unwatch func()) {
+	s.sendStatusWatch(StatusWatch{ch, true})
+	return func() { s.sendStatusWatch(StatusWatch{ch, false}) }
+}
+
+func (s *System) sendStatusWatch(watch StatusWatch) {
+	for _, e := range s.elevators {
+		expect(e.StatusWatches())
+		select {
+		case e.StatusWatches() <- watch:
+		case <-s.life.quit:
+			unexpect(e.StatusWatches())
+			return
+		}
+	}
+}
+
+// Returns the outstanding hall calls, by floor and then direction (UP first); or nil if the System is closed.
+// Safe to call from any goroutine.
+func (s *System) HallCalls() []HallCallStatus {
+	chReply := make(chan []HallCallStatus, 1)
+	expect(s.chHalls)
+	select {
+	case s.chHalls <- chReply:
+		return <-chReply
+	case <-s.life.quit:
+		unexpect(s.chHalls)
+		return nil
+	}
+}
+
+func (s *System) hallCalls() []HallCallStatus {
+	halls := make([]HallCallStatus, 0, len(s.calls))
+	for fd, call := range s.calls {
+		hall := HallCallStatus{Floor: fd.floor, Dir: fd.dir, Waiting: len(s.waiters[fd]), Since: call.since, Car: -1}
+		if call.car != nil {
+			hall.Car = call.car.Id()
+		}
+		halls = append(halls, hall)
+	}
+	sort.Slice(halls, func(i, k int) bool {
+		if halls[i].Floor != halls[k].Floor {
+			return halls[i].Floor < halls[k].Floor
+		}
+		return halls[i].Dir == UP && halls[k].Dir != UP
+	})
+	return halls
+}
+
+// Returns how often the aging rule has fired so far. Safe to call from any goroutine.
+func (s *System) Aging() AgingStats {
+	return AgingStats{atomic.LoadInt64(&s.aging.Escalated), atomic.LoadInt64(&s.aging.AgedPickups)}
+}
+
+// Creates one Elevator per CarSpec. See DefaultCarSpecs. The System and its Elevators send their Events to
+// events, and record the requests they receive in the journal, unless these are nil.
+func NewSystem(numFloors int, cars []CarSpec, dispatcher Dispatcher, clock Clock, events EventSink, journal *Journal) *System {
+	s := makeSystem(numFloors, cars, dispatcher, clock, events, journal)
+	s.start()
 	return s
 }
 
-func (s *System) mainLoop() {
-	// Assumptions: all elevators are at floor 0, and all buttons are cleared
+// Returns a System whose elevators are at rest at the bottom floor, with no requests. Neither it nor they run
+// until start.
+func makeSystem(numFloors int, cars []CarSpec, dispatcher Dispatcher, clock Clock, events EventSink, journal *Journal) *System {
+	eventLog := newEventLog(events, clock)
+	elevators := make([]Conveyor, len(cars)) // <sigh> In Python, these 4 lines would just be a List Comprehension: [ NewElevator(i, numFloors) for i in range(numFloors) ]
+	for i, spec := range cars {
+		elevators[i] = makeElevator(i, numFloors, spec, clock, eventLog, journal)
+	}
+	s := &System{elevators: elevators, pickupsUp: newFloorSet(numFloors), pickupsDown: newFloorSet(numFloors),
+		chPickups: make(chan Pickup), chArrivals: make(chan Arrival), chReturns: make(chan Pickup),
+		waiters: make(ArrivalListeners), dispatcher: dispatcher, life: lifecycle{quit: make(chan struct{}), clock: clock},
+		clock: clock, maxWaits: make([]time.Duration, len(cars)), capacities: make([]Capacity, len(cars)),
+		calls:    make(map[FloorDir]*hallCall),
+		watchers: make(map[chan<- Arrival]bool), chWatch: make(chan watchRequest),
+		chHalls: make(chan chan<- []HallCallStatus), chSnapshots: make(chan chan<- *Snapshot), events: eventLog,
+		journal: journal, chFaults: make(chan FaultReport), chModes: make(chan modeRequest)}
+	for i, spec := range cars {
+		s.maxWaits[i] = spec.MaxWait
+		s.capacities[i] = spec.Capacity
+	}
+	return s
+}
+
+func (s *System) start() {
+	for _, e := range s.elevators {
+		e := e
+		e.(*Elevator).start()
+		s.life.goroutine(func(p *Participant) { s.forwardArrivals(p, e) }, e.Arrivals())
+		s.life.goroutine(func(p *Participant) { s.forwardReturns(p, e) }, e.PickupReturns())
+		s.life.goroutine(func(p *Participant) { s.forwardFaults(p, e) }, e.Faults())
+	}
+	s.life.goroutine(s.mainLoop, s.chPickups, s.chArrivals, s.chReturns, s.chFaults, s.chModes, s.chWatch, s.chHalls,
+		s.chSnapshots)
+}
+
+// Stops the System and its elevators, and waits until all their goroutines have returned.
+// Outstanding pickups and dropoffs are Cancelled (see Conveyor.Close). Calling Close again does nothing.
+func (s *System) Close() {
+	s.life.close()
+	for _, e := range s.elevators {
+		e.Close()
+	}
+}
+
+// Merges the Arrivals of one elevator into s.chArrivals.
+func (s *System) forwardArrivals(p *Participant, e Conveyor) {
+	for {
+		p.Idle()
+		select {
+		case arrival := <-e.Arrivals():
+			expect(s.chArrivals)
+			select {
+			case s.chArrivals <- arrival:
+			case <-s.life.quit:
+				unexpect(s.chArrivals)
+				return
+			}
+		case <-s.life.quit:
+			return
+		}
+	}
+}
+
+// Merges the PickupReturns of one elevator into s.chReturns.
+func (s *System) forwardReturns(p *Participant, e Conveyor) {
 	for {
+		p.Idle()
+		select {
+		case pickup := <-e.PickupReturns():
+			expect(s.chReturns)
+			select {
+			case s.chReturns <- pickup:
+			case <-s.life.quit:
+				unexpect(s.chReturns)
+				s.cancel(pickup)
+				return
+			}
+		case <-s.life.quit:
+			return
+		}
+	}
+}
+
+func (s *System) mainLoop(p *Participant) {
+	// Assumptions: all elevators are at floor 0, and all buttons are cleared (unless restored from a Snapshot,
+	// which may leave pickups unassigned).
+	s.retryUnassigned()
+	s.scheduleAging()
+	for {
+		p.Idle(s.agingTimer)
 		select {
 		case pickupReq := <-s.chPickups:
 			s.onPickupReq(pickupReq)
-			//			case arrival := <-s.chArrivals:			// Currently, we don't subscribe to these.
-			//				s.onArrival(arrival)
+		case arrival := <-s.chArrivals:
+			s.onArrival(arrival)
+		case pickup := <-s.chReturns:
+			s.onPickupReturn(pickup)
+		case report := <-s.chFaults:
+			s.onFault(report)
+		case req := <-s.chModes:
+			s.onModeRequest(req)
+		case req := <-s.chWatch:
+			if req.watch {
+				s.watchers[req.ch] = true
+			} else {
+				delete(s.watchers, req.ch)
+			}
+		case chReply := <-s.chHalls:
+			chReply <- s.hallCalls()
+		case chReply := <-s.chSnapshots:
+			chReply <- s.snapshot()
+		case <-s.agingTimer:
+			s.agingTimer, s.agingDeadline = nil, time.Time{}
+			s.onAgingTimer()
+		case <-s.life.quit:
+			log.Printf("System shutting down, cancelling %d pending pickups\n", s.pickupsUp.count()+s.pickupsDown.count())
+			s.emitCancellations()
+			s.waiters.cancelAll(nil, &s.life)
+			return
 		}
+		s.scheduleAging()
+	}
+}
+
+// Tells the passengers waiting for a returned pickup that it was Cancelled.
+func (s *System) cancel(pickup Pickup) {
+	if pickup.Done != nil {
+		s.life.notify(pickup.Done, Arrival{Floor: pickup.Floor, Dir: pickup.Dir, Outcome: Cancelled})
+	}
+}
+
+func (s *System) pickups(dir Direction) *FloorSet {
+	switch dir {
+	case UP:
+		return s.pickupsUp
+	case DOWN:
+		return s.pickupsDown
+	default:
+		panic(fmt.Sprintf("Direction must be up or down, found %d", dir))
 	}
 }
 
 func (s *System) onPickupReq(pickupReq Pickup) {
 	log.Printf("System got %v\n", pickupReq)
-	//	s.addArrivalListener(FloorDir(pickupReq.Pickup), pickupReq.Done)
-	//	if ! s.pickups(pickupReq.dir).set(pickupReq.floor) {
-	// Find a suitable elevator
-	// FUTURE: Send PickupQuery to (some/all) elevators and choose the best result.
-	// For now, random:
-	id := rand.Intn(len(s.elevators))
-	e := s.elevators[id]
-	log.Printf("System sending %v to Elevator-%d\n", pickupReq, id)
-	e.Pickups() <- pickupReq
-	//	}
-}
-
-/* FUTURE: As optimization, we should track the set/cleared status of each Floor's UP/DOWN buttons,
-   and not dispatch multiple elevators. But let's get the basics working first.
-   This seems to require redirecting all Arrivals to System:
-    onPickup, store the pickup.done in ArrivalListeners and replace the done channel with one we listen on.
-   adding, and having a goroutine */
-//func (s *System) pickups(dir Direction) *FloorSet {
-//	switch dir {
-//	case UP:
-//		return s.pickupsUp
-//	case DOWN:
-//		return s.pickupsDown
-//	default:
-//		panic(fmt.Sprintf("Direction must be up or down, found %d", dir))
-//	}
-//}
-
-// Needed because GoLang lacks generics. In Java, would be Map<FloorDir, Set<ArrivalChannel>>
-//func (s *System) onArrival(arrival elevatorArrival) {
-//	log.Printf("System got %v\n", arrival.arrival)
-//	s.pickups(arrival.dir).clear(arrival.floor)
-//	for _, ch := range s.pickupsToReplies[FloorDir(arrival.arrival)] {
-//		// Signal all passengers (pickups) waiting on this FloorDir
-//		log.Printf("Signalling arrival to receiver %v\n", ch)
-//		ch <- arrival.dropoffReqs
-//		log.Println("Signalled arrival")
-//	}
-//}
+	s.journal.pickup(pickupReq)
+	if !s.fits(pickupReq.Persons) {
+		log.Printf("System refused %v: %d persons fit in no car\n", pickupReq, pickupReq.Persons)
+		if pickupReq.Done != nil {
+			s.life.notify(pickupReq.Done, Arrival{Floor: pickupReq.Floor, Dir: pickupReq.Dir, Outcome: Oversized})
+		}
+		return
+	}
+	floorDir := pickupReq.FloorDir()
+	call := s.call(floorDir)
+	s.events.emit(Event{Kind: EventHallCall, Car: -1, Floor: pickupReq.Floor, Dir: eventDir(pickupReq.Dir), Call: call.id})
+	call.join(pickupReq)
+	s.waiters.addPickupListener(pickupReq)
+	if !s.pickups(pickupReq.Dir).set(pickupReq.Floor) { // set() returns previous value.
+		s.dispatch(floorDir)
+		return
+	}
+	log.Printf("System has dispatched %v already\n", pickupReq)
+	if call.car != nil && !s.carFits(call.car.Id(), call.persons) {
+		log.Printf("System: %d persons do not fit Elevator-%d, dispatching %v again\n", call.persons, call.car.Id(), pickupReq)
+		expect(call.car.PickupCancellations())
+		call.car.PickupCancellations() <- Pickup{Floor: floorDir.floor, Dir: floorDir.dir}
+		s.dispatch(floorDir)
+	}
+}
+
+// Returns true if a group of persons (if known) would fit in one of the cars, empty.
+func (s *System) fits(persons int) bool {
+	for id := range s.capacities {
+		if s.carFits(id, persons) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if a group of persons (if known) would fit in car id, empty.
+func (s *System) carFits(id int, persons int) bool {
+	return persons <= 0 || !groupLoad(persons).exceeds(s.capacities[id], 1)
+}
+
+// Find a suitable elevator for the hall call: send PickupQuery to all elevators and let the Dispatcher choose
+// among those which do not bypass it. If all do, we keep the call until an elevator can take it.
+// The elevator notifies us (not the passenger) via its Arrivals channel.
+func (s *System) dispatch(floorDir FloorDir) {
+	call := s.call(floorDir)
+	pickup := call.pickup(floorDir)
+	call.car = nil
+	var candidates []PickupEstimate
+	for _, est := range s.estimates(pickup) {
+		if !est.Bypass {
+			candidates = append(candidates, est)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("System found no elevator for %v, will retry\n", pickup)
+		s.unassigned = append(s.unassigned, floorDir)
+		return
+	}
+	e := s.dispatcher.Dispatch(pickup, candidates)
+	log.Printf("System sending %v to Elevator-%d\n", pickup, e.Id())
+	call.car = e
+	s.emitAssignment(pickup, e)
+	expect(e.Pickups())
+	e.Pickups() <- pickup
+}
+
+// Returns the outstanding hall call at floorDir, or a new one from now.
+func (s *System) call(floorDir FloorDir) *hallCall {
+	call := s.calls[floorDir]
+	if call == nil {
+		s.lastCall++
+		call = &hallCall{id: s.lastCall, since: s.clock.Now()}
+		s.calls[floorDir] = call
+	}
+	return call
+}
+
+func (s *System) emitAssignment(pickup Pickup, e Conveyor) {
+	s.events.emit(Event{Kind: EventAssignment, Car: e.Id(), Floor: pickup.Floor, Dir: eventDir(pickup.Dir),
+		Call: pickup.Call, Aged: pickup.Aged})
+}
+
+// We are closing: the outstanding hall calls will not be made. (In floor order, as in onAgingTimer.)
+func (s *System) emitCancellations() {
+	if s.events == nil {
+		return
+	}
+	for _, floorDir := range s.sortedCalls() {
+		s.events.emit(Event{Kind: EventCancellation, Car: -1, Floor: floorDir.floor, Dir: eventDir(floorDir.dir),
+			Call: s.calls[floorDir].id})
+	}
+}
+
+// Returns the FloorDirs of the outstanding hall calls, in floor order (DOWN first).
+func (s *System) sortedCalls() []FloorDir {
+	var floorDirs []FloorDir
+	for floorDir := range s.calls {
+		floorDirs = append(floorDirs, floorDir)
+	}
+	sortFloorDirs(floorDirs)
+	return floorDirs
+}
+
+func sortFloorDirs(floorDirs []FloorDir) {
+	sort.Slice(floorDirs, func(i, j int) bool {
+		if floorDirs[i].floor != floorDirs[j].floor {
+			return floorDirs[i].floor < floorDirs[j].floor
+		}
+		return floorDirs[i].dir < floorDirs[j].dir
+	})
+}
+
+// An elevator handed back a pickup (e.g., it is full): dispatch it again, unless it was made meanwhile.
+func (s *System) onPickupReturn(pickup Pickup) {
+	log.Printf("System got back %v\n", pickup)
+	if pickup.Done != nil {
+		s.waiters.addPickupListener(pickup) // Not one of ours, but we will look after it.
+		s.call(pickup.FloorDir()).join(pickup)
+		if !s.pickups(pickup.Dir).set(pickup.Floor) {
+			s.dispatch(pickup.FloorDir())
+		}
+		return
+	}
+	if s.pickups(pickup.Dir).isSet(pickup.Floor) {
+		s.dispatch(pickup.FloorDir())
+	}
+}
+
+// Dispatches the unassigned hall calls again, if they are still outstanding.
+func (s *System) retryUnassigned() {
+	unassigned := s.unassigned
+	s.unassigned = nil
+	for _, floorDir := range unassigned {
+		if s.pickups(floorDir.dir).isSet(floorDir.floor) {
+			s.dispatch(floorDir)
+		}
+	}
+}
+
+// Queries every elevator for a PickupEstimate, and adds the passengers of the hall calls dispatched to it.
+// Estimates are returned in id order.
+func (s *System) estimates(pickupReq Pickup) []PickupEstimate {
+	chReply := make(chan PickupEstimate)
+	estimates := make([]PickupEstimate, len(s.elevators))
+	for i, e := range s.elevators {
+		expect(e.PickupQueries())
+		e.PickupQueries() <- PickupQuery{pickupReq, chReply}
+		estimates[i] = <-chReply
+		for _, call := range s.calls {
+			if call.car == e {
+				estimates[i].Assigned += groupLoad(call.persons).persons
+			}
+		}
+		log.Printf("System got estimate from Elevator-%d: %d stops, %d floors, going there anyway: %v\n",
+			e.Id(), estimates[i].StopsUntilPickup, estimates[i].DistanceUntilPickup, estimates[i].GoingThereAnyway)
+	}
+	return estimates
+}
+
+// An elevator stopped at a floor. If it serves an outstanding pickup, signal all passengers waiting on
+// this FloorDir, and cancel the pickup on the other elevators (whichever was dispatched, it need not go there now).
+func (s *System) onArrival(arrival Arrival) {
+	for ch := range s.watchers {
+		select {
+		case ch <- arrival:
+		default: // The watcher is behind: it misses this Arrival.
+		}
+	}
+	// Passengers got out, so a full elevator may have room again.
+	if arrival.Alighted > 0 && len(s.unassigned) > 0 {
+		defer s.retryUnassigned()
+	}
+	if arrival.Dir == IDLE || !s.pickups(arrival.Dir).clear(arrival.Floor) { // clear() returns previous value.
+		return
+	}
+	log.Printf("System got arrival of Elevator-%d at %s %s\n", arrival.Conveyor.Id(), arrival.Floor, arrival.Dir)
+	if call := s.calls[arrival.FloorDir()]; call != nil {
+		s.events.emit(Event{Kind: EventServed, Car: arrival.Conveyor.Id(), Floor: arrival.Floor, Dir: eventDir(arrival.Dir),
+			Call: call.id, Aged: arrival.Aged})
+	}
+	delete(s.calls, arrival.FloorDir())
+	if arrival.Aged {
+		atomic.AddInt64(&s.aging.AgedPickups, 1)
+	}
+	s.waiters.notifyArrival(arrival, &s.life)
+	cancellation := Pickup{Floor: arrival.Floor, Dir: arrival.Dir}
+	for _, e := range s.elevators {
+		if e != arrival.Conveyor {
+			expect(e.PickupCancellations())
+			e.PickupCancellations() <- cancellation
+		}
+	}
+}
+
+// Returns when the call will have waited longer than the MaxWait of its car. False if it cannot:
+// it is unassigned, its car has no MaxWait, or it was escalated already.
+func (s *System) agingDeadlineOf(call *hallCall) (time.Time, bool) {
+	if call.car == nil || call.escalated || s.maxWaits[call.car.Id()] <= 0 {
+		return time.Time{}, false
+	}
+	return call.since.Add(s.maxWaits[call.car.Id()]), true
+}
+
+// Sets the aging timer for the first call which will outwait its car, unless it is set for then already.
+func (s *System) scheduleAging() {
+	var next time.Time
+	for _, call := range s.calls {
+		if deadline, ok := s.agingDeadlineOf(call); ok && (next.IsZero() || deadline.Before(next)) {
+			next = deadline
+		}
+	}
+	if next.Equal(s.agingDeadline) {
+		return
+	}
+	s.agingDeadline, s.agingTimer = next, nil
+	if !next.IsZero() {
+		s.agingTimer = s.clock.After(next.Sub(s.clock.Now()))
+	}
+}
+
+// The aging rule of dispatch: escalate every call which has waited longer than its car's MaxWait.
+// (In floor order, so that a simulation gives the same results on every run.)
+func (s *System) onAgingTimer() {
+	now := s.clock.Now()
+	var overdue []FloorDir
+	for floorDir, call := range s.calls {
+		if deadline, ok := s.agingDeadlineOf(call); ok && !now.Before(deadline) {
+			overdue = append(overdue, floorDir)
+		}
+	}
+	sortFloorDirs(overdue)
+	for _, floorDir := range overdue {
+		s.escalate(floorDir, s.calls[floorDir])
+	}
+}
+
+// Takes the call from its car, and sends it as an Aged pickup to whichever car can make it soonest (which may be
+// the same one). The car serves it before its other requests. If no other car can take it, the call stays put.
+func (s *System) escalate(floorDir FloorDir, call *hallCall) {
+	call.escalated = true
+	atomic.AddInt64(&s.aging.Escalated, 1)
+	pickup := call.pickup(floorDir)
+	pickup.Aged = true
+	var candidates []PickupEstimate
+	for _, est := range s.estimates(pickup) {
+		if !est.Bypass {
+			candidates = append(candidates, est)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("System: %v has waited %v, but no elevator can take it sooner\n", pickup, s.clock.Now().Sub(call.since))
+		return
+	}
+	e := ETADispatcher{}.Dispatch(pickup, candidates)
+	log.Printf("System: %v has waited %v, escalating from Elevator-%d to Elevator-%d\n", pickup,
+		s.clock.Now().Sub(call.since), call.car.Id(), e.Id())
+	if e != call.car {
+		expect(call.car.PickupCancellations())
+		call.car.PickupCancellations() <- Pickup{Floor: floorDir.floor, Dir: floorDir.dir}
+	}
+	call.car = e
+	s.emitAssignment(pickup, e)
+	expect(e.Pickups())
+	e.Pickups() <- pickup
+}
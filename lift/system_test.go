@@ -0,0 +1,72 @@
+package lift
+
+import (
+	"testing"
+	"time"
+)
+
+// Once a car serves a hall call, the System cancels it on the car it was sent to, which need not go there now.
+func TestServedCallCancelledElsewhere(t *testing.T) {
+	clock := NewVirtualClock(Epoch)
+	defer clock.Stop()
+	events := &eventRecorder{}
+	s := NewSystem(6, DefaultCarSpecs(2), firstDispatcher{}, clock, events, nil)
+	defer s.Close()
+	p := Join(clock)
+	defer p.Leave()
+
+	// Elevator-1 sets off for 3 and 5. As it nears 3, a hall call there goes to Elevator-0, at 0.
+	for _, f := range []Floor{3, 5} {
+		if err := SendDropoff(s.Conveyors()[1], Dropoff{Floor: f, Done: make(chan Arrival, 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Sleep(6 * time.Second)
+	done := doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 3, Dir: UP, Done: done}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dispatchedTo(s, FloorDir{3, UP}); got != 0 {
+		t.Fatalf("3 UP went to Elevator-%d, want Elevator-0", got)
+	}
+	awaitArrival(t, p, done, 1, "3 UP")
+	p.Sleep(time.Minute)
+
+	if status := s.Status()[0]; len(status.PickupsUp) != 0 || len(status.PickupsDown) != 0 {
+		t.Errorf("Elevator-0 still has pickups: %v UP, %v DOWN", status.PickupsUp, status.PickupsDown)
+	}
+	for _, ev := range events.of(EventStop) {
+		if ev.Car == 0 && ev.Floor == 3 {
+			t.Errorf("Elevator-0 went on to 3, at %v", ev.Time.Sub(Epoch))
+		}
+	}
+	if n := len(s.HallCalls()); n != 0 {
+		t.Errorf("%d hall calls outstanding, want none", n)
+	}
+}
+
+// Close cancels the requests outstanding, and after it, requests are refused with ErrClosed.
+func TestCloseCancelsRequests(t *testing.T) {
+	s, p, close := testSystem(6, DefaultCarSpecs(2))
+	defer close()
+	car := s.Conveyors()[1]
+
+	pickup, dropoff := doneChan(p), doneChan(p)
+	if err := SendPickup(s, Pickup{Floor: 4, Dir: DOWN, Done: pickup}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendDropoff(car, Dropoff{Floor: 5, Done: dropoff}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	awaitOutcome(t, p, pickup, Cancelled, -1, "4 DOWN")
+	awaitOutcome(t, p, dropoff, Cancelled, 1, "to 5")
+
+	if err := SendPickup(s, Pickup{Floor: 2, Dir: UP}); err != ErrClosed {
+		t.Errorf("after Close, SendPickup returned %v, want ErrClosed", err)
+	}
+	if err := SendDropoff(car, Dropoff{Floor: 2}); err != ErrClosed {
+		t.Errorf("after Close, SendDropoff returned %v, want ErrClosed", err)
+	}
+	s.Close() // Does nothing.
+}
@@ -0,0 +1,202 @@
+// Package trace reads and writes recorded passenger traffic, e.g. from a building's hall-call logs,
+// so it can be replayed through a System (see sim.Run) instead of synthetic traffic.
+//
+// A trace is a list of records: a timestamp, an origin floor, a destination floor, and optionally
+// a group size. It is written as CSV, with an optional header line:
+//
+//	time,origin,dest,persons
+//	08:00:05,G,7,1
+//	08:00:09,G,12,3
+//
+// or as a JSON array:
+//
+//	[{"time": "08:00:05", "origin": "G", "dest": 7}, ...]
+//
+// Timestamps are either relative (seconds such as 12.5, or durations such as "1m30s") or absolute
+// (times of day such as "08:00:05", or RFC 3339 times). Absolute traces start at their earliest record.
+// Floors are numbers or labels; see lift.Building.ParseFloor. A group must fit in the largest car (see
+// lift.Building.MaxGroup), or it could never board.
+package trace
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"github.com/delliston/mygo/lift/sim"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses a floor number or label.
+type FloorParser func(s string) (lift.Floor, error)
+
+// One record of a trace, as written in JSON. Floors and the time may be strings or numbers.
+type Record struct {
+	Time    json.RawMessage `json:"time"`
+	Origin  json.RawMessage `json:"origin"`
+	Dest    json.RawMessage `json:"dest"`
+	Persons int             `json:"persons,omitempty"`
+}
+
+// Reads a trace file. The format is chosen by the extension: .json, or else CSV. A record of a group larger than
+// maxPersons is an error, unless maxPersons is zero.
+func Load(path string, floor FloorParser, maxPersons int) ([]sim.Call, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var calls []sim.Call
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		calls, err = ReadJSON(f, floor, maxPersons)
+	} else {
+		calls, err = ReadCSV(f, floor, maxPersons)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return calls, nil
+}
+
+func ReadCSV(r io.Reader, floor FloorParser, maxPersons int) ([]sim.Call, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	var rows []row
+	for first := true; ; first = false {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(fields[0]), "time") {
+			continue // Header
+		}
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: want time, origin, dest and optional persons, got %d fields", line, len(fields))
+		}
+		r := row{line: line, time: fields[0], origin: fields[1], dest: fields[2]}
+		if len(fields) == 4 && strings.TrimSpace(fields[3]) != "" {
+			if r.persons, err = strconv.Atoi(strings.TrimSpace(fields[3])); err != nil {
+				return nil, fmt.Errorf("line %d: persons: %v", line, err)
+			}
+		}
+		rows = append(rows, r)
+	}
+	return toCalls(rows, floor, maxPersons, "line")
+}
+
+func ReadJSON(r io.Reader, floor FloorParser, maxPersons int) ([]sim.Call, error) {
+	var records []Record
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	rows := make([]row, len(records))
+	for i, rec := range records {
+		rows[i] = row{line: i + 1, time: unquote(rec.Time), origin: unquote(rec.Origin), dest: unquote(rec.Dest), persons: rec.Persons}
+	}
+	return toCalls(rows, floor, maxPersons, "record")
+}
+
+// Writes calls as a CSV trace, with relative times in seconds and floors as labelled, which ReadCSV reads back.
+func WriteCSV(w io.Writer, calls []sim.Call, label func(lift.Floor) string) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "origin", "dest", "persons"})
+	for _, c := range calls {
+		persons := c.Persons
+		if persons == 0 {
+			persons = 1
+		}
+		cw.Write([]string{strconv.FormatFloat(c.At.Seconds(), 'f', -1, 64), label(c.Start), label(c.Dest), strconv.Itoa(persons)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// A record before parsing.
+type row struct {
+	line               int
+	time, origin, dest string
+	persons            int
+}
+
+// Returns the Calls of the rows, sorted by time. The rows are numbered by unit ("line" or "record") in errors.
+func toCalls(rows []row, floor FloorParser, maxPersons int, unit string) ([]sim.Call, error) {
+	calls := make([]sim.Call, len(rows))
+	times := make([]time.Time, len(rows)) // If absolute
+	absolute := false
+	for i, r := range rows {
+		at, t, isAbsolute, err := parseTime(r.time)
+		if err != nil {
+			return nil, fmt.Errorf("%s %d: %v", unit, r.line, err)
+		}
+		if i == 0 {
+			absolute = isAbsolute
+		} else if isAbsolute != absolute {
+			return nil, fmt.Errorf("%s %d: mixes absolute and relative times", unit, r.line)
+		}
+		calls[i].At, times[i] = at, t
+		if calls[i].Start, err = floor(r.origin); err != nil {
+			return nil, fmt.Errorf("%s %d: origin: %v", unit, r.line, err)
+		}
+		if calls[i].Dest, err = floor(r.dest); err != nil {
+			return nil, fmt.Errorf("%s %d: dest: %v", unit, r.line, err)
+		}
+		if r.persons < 0 {
+			return nil, fmt.Errorf("%s %d: persons must be positive", unit, r.line)
+		}
+		if maxPersons > 0 && r.persons > maxPersons {
+			return nil, fmt.Errorf("%s %d: a group of %d persons fits in no car: the largest holds %d", unit, r.line,
+				r.persons, maxPersons)
+		}
+		calls[i].Persons = r.persons
+	}
+	if len(calls) > 0 && absolute {
+		first := times[0]
+		for _, t := range times {
+			if t.Before(first) {
+				first = t
+			}
+		}
+		for i := range calls {
+			calls[i].At = times[i].Sub(first)
+		}
+	}
+	sim.SortCalls(calls)
+	return calls, nil
+}
+
+// Parses a relative time (returned as a duration) or an absolute time (returned as a time).
+func parseTime(s string) (time.Duration, time.Time, bool, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), time.Time{}, false, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, time.Time{}, false, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "15:04:05.999999999", "15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return 0, t, true, nil
+		}
+	}
+	return 0, time.Time{}, false, fmt.Errorf("bad time %q: want seconds, a duration, a time of day, or an RFC 3339 time", s)
+}
+
+// Returns a JSON string's contents, or other JSON (e.g. a number) as is.
+func unquote(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
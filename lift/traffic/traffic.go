@@ -0,0 +1,195 @@
+// Package traffic generates passenger Calls following the standard patterns of building traffic.
+//
+// Passengers arrive as a Poisson process, whose rate varies over the day according to a Profile.
+// Each passenger's trip is incoming (from the lobby), outgoing (to the lobby), or interfloor,
+// in the proportions given by the Mix of the current Period.
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"github.com/delliston/mygo/lift/sim"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// The proportions of trips of each kind. They need not add up to one.
+type Mix struct {
+	Incoming   float64 `json:"incoming"`   // From the lobby, to another floor.
+	Outgoing   float64 `json:"outgoing"`   // From another floor, to the lobby.
+	Interfloor float64 `json:"interfloor"` // Between two floors other than the lobby.
+}
+
+// The standard patterns.
+var (
+	UpPeak     = Mix{Incoming: 0.85, Outgoing: 0.05, Interfloor: 0.10} // Morning arrivals.
+	DownPeak   = Mix{Incoming: 0.05, Outgoing: 0.85, Interfloor: 0.10} // Evening departures.
+	Lunch      = Mix{Incoming: 0.45, Outgoing: 0.45, Interfloor: 0.10} // Two-way.
+	Interfloor = Mix{Incoming: 0.10, Outgoing: 0.10, Interfloor: 0.80} // Random moves during the day.
+)
+
+var Patterns = map[string]Mix{"up-peak": UpPeak, "down-peak": DownPeak, "lunch": Lunch, "interfloor": Interfloor}
+
+// During [Start, End), passengers arrive at Rate per minute, with the Mix of trips.
+type Period struct {
+	Start lift.Duration `json:"start"`
+	End   lift.Duration `json:"end"`
+	Rate  float64       `json:"rate"`
+	Mix   Mix           `json:"mix"`
+}
+
+// The Periods of a Profile are sorted, and do not overlap. Gaps have no traffic.
+type Profile []Period
+
+// Returns a Profile of one pattern at a constant rate (per minute).
+func Constant(mix Mix, rate float64, duration time.Duration) Profile {
+	return Profile{{Start: 0, End: lift.Duration(duration), Rate: rate, Mix: mix}}
+}
+
+// Returns the Profile of a typical office day (24 hours from midnight), peaking at peakRate per minute.
+func Day(peakRate float64) Profile {
+	period := func(from, to time.Duration, fraction float64, mix Mix) Period {
+		return Period{lift.Duration(from), lift.Duration(to), fraction * peakRate, mix}
+	}
+	h := time.Hour
+	return Profile{
+		period(0, 7*h, 0.02, Interfloor),
+		period(7*h, 8*h, 0.5, UpPeak),
+		period(8*h, 9*h+30*time.Minute, 1, UpPeak),
+		period(9*h+30*time.Minute, 12*h, 0.3, Interfloor),
+		period(12*h, 13*h+30*time.Minute, 0.6, Lunch),
+		period(13*h+30*time.Minute, 17*h, 0.3, Interfloor),
+		period(17*h, 18*h+30*time.Minute, 1, DownPeak),
+		period(18*h+30*time.Minute, 20*h, 0.3, DownPeak),
+		period(20*h, 24*h, 0.02, Interfloor),
+	}
+}
+
+// Returns a built-in Profile by name: "day", or one of Patterns for an hour at the given rate.
+func NamedProfile(name string, rate float64) (Profile, error) {
+	if name == "day" {
+		return Day(rate), nil
+	}
+	if mix, ok := Patterns[name]; ok {
+		return Constant(mix, rate, time.Hour), nil
+	}
+	return nil, fmt.Errorf("unknown traffic profile %q (want day, up-peak, down-peak, lunch or interfloor)", name)
+}
+
+// Reads a Profile from a JSON file: an array of Periods, whose mix may also be a pattern name, e.g.
+//
+//	[{"start": "8h", "end": "9h", "rate": 12, "mix": "up-peak"}]
+func LoadProfile(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw []struct {
+		Period
+		Mix json.RawMessage `json:"mix"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	profile := make(Profile, len(raw))
+	for i, r := range raw {
+		profile[i] = r.Period
+		var name string
+		if err := json.Unmarshal(r.Mix, &name); err == nil {
+			mix, ok := Patterns[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: period %d: unknown pattern %q", path, i, name)
+			}
+			profile[i].Mix = mix
+		} else if err := json.Unmarshal(r.Mix, &profile[i].Mix); err != nil {
+			return nil, fmt.Errorf("%s: period %d: %v", path, i, err)
+		}
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return profile, nil
+}
+
+func (p Profile) Validate() error {
+	for i, period := range p {
+		if period.End < period.Start || period.Rate < 0 {
+			return fmt.Errorf("period %d: must have start <= end, and rate >= 0", i)
+		}
+		if period.Mix.Incoming < 0 || period.Mix.Outgoing < 0 || period.Mix.Interfloor < 0 ||
+			period.Mix.Incoming+period.Mix.Outgoing+period.Mix.Interfloor == 0 {
+			return fmt.Errorf("period %d: mix must be positive", i)
+		}
+		if i > 0 && period.Start < p[i-1].End {
+			return fmt.Errorf("period %d overlaps period %d", i, i-1)
+		}
+	}
+	return nil
+}
+
+// Generates Calls for a building.
+type Generator struct {
+	Floors int
+	Lobby  lift.Floor // Where incoming passengers start, and outgoing passengers go.
+	Rand   *rand.Rand // The same seed generates the same calls.
+}
+
+func NewGenerator(floors int, lobby lift.Floor, seed int64) *Generator {
+	return &Generator{floors, lobby, rand.New(rand.NewSource(seed))}
+}
+
+// Returns the Calls of the Profile, sorted by time.
+func (g *Generator) Generate(profile Profile) []sim.Call {
+	var calls []sim.Call
+	for _, period := range profile {
+		if period.Rate <= 0 {
+			continue
+		}
+		// Exponential inter-arrival times. The process is memoryless, so we may restart it at each period.
+		mean := float64(time.Minute) / period.Rate
+		for at := time.Duration(period.Start); ; {
+			at += time.Duration(g.Rand.ExpFloat64() * mean)
+			if at >= time.Duration(period.End) {
+				break
+			}
+			start, dest := g.trip(period.Mix)
+			calls = append(calls, sim.Call{At: at, Start: start, Dest: dest, Persons: 1})
+		}
+	}
+	return calls
+}
+
+// Returns the start and dest of a random trip of the mix.
+func (g *Generator) trip(mix Mix) (lift.Floor, lift.Floor) {
+	x := g.Rand.Float64() * (mix.Incoming + mix.Outgoing + mix.Interfloor)
+	switch {
+	case x < mix.Incoming:
+		return g.Lobby, g.otherFloor()
+	case x < mix.Incoming+mix.Outgoing:
+		return g.otherFloor(), g.Lobby
+	default:
+		start := g.otherFloor()
+		dest := g.otherFloor(start)
+		return start, dest
+	}
+}
+
+// Returns a random floor, other than the lobby and the excluded floors. Returns the lobby if there is none.
+func (g *Generator) otherFloor(exclude ...lift.Floor) lift.Floor {
+	var choices []lift.Floor
+	for f := lift.Floor(0); int(f) < g.Floors; f++ {
+		ok := f != g.Lobby
+		for _, x := range exclude {
+			ok = ok && f != x
+		}
+		if ok {
+			choices = append(choices, f)
+		}
+	}
+	if len(choices) == 0 {
+		return g.Lobby
+	}
+	return choices[g.Rand.Intn(len(choices))]
+}
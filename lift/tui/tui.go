@@ -0,0 +1,314 @@
+// Package tui draws a running System in a terminal, once per Tick of its VirtualClock, and controls the clock
+// from the keyboard:
+//
+//	space   pause or resume
+//	s       step one Tick (pausing first)
+//	+ -     double or halve the speed
+//	q       quit
+//
+// Each shaft is a column. A car shows its direction, doors ([ ] closed, | | moving, ] [ open) and load, and the
+// floors it will drop passengers at are marked in its shaft. A car with a fault (see lift.Fault) is shown in red;
+// one out of normal service (see lift.CarMode) in blue.
+// Beside the floor labels are the lit hall buttons, and how many passengers wait at each floor. It needs only a
+// terminal which understands ANSI escape codes (so it works over ssh), and stty to read keys as they are pressed.
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/delliston/mygo/lift"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minFrame = 40 * time.Millisecond // We draw at most this often (in real time), however fast the clock runs.
+	maxSpeed = 1024
+	minSpeed = 1.0 / 16
+
+	reset   = "\x1b[0m"
+	bold    = "\x1b[1m"
+	dim     = "\x1b[2m"
+	reverse = "\x1b[7m"
+	yellow  = "\x1b[33m"
+	onGreen = "\x1b[30;42m"
+	onAmber = "\x1b[30;43m"
+	onRed   = "\x1b[30;41m"
+	onBlue  = "\x1b[30;44m"
+)
+
+var arrows = map[lift.Direction]string{lift.UP: "▲", lift.DOWN: "▼", lift.IDLE: "·"}
+
+type hallKey struct {
+	floor lift.Floor
+	dir   lift.Direction
+}
+
+// A Screen draws a System on a terminal until Close.
+type Screen struct {
+	system   *lift.System
+	building *lift.Building
+	clock    *lift.VirtualClock
+	out      io.Writer
+	keyboard *os.File      // The terminal, read in cbreak mode. nil if there is none: then we only draw.
+	restore  func()        // Restores the terminal. Safe to call more than once.
+	quitKey  chan struct{} // Closed when the q key is pressed.
+	keys     chan byte
+	lastDraw time.Time
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Clears the terminal, and starts drawing the System on out. See Quit.
+func Start(system *lift.System, building *lift.Building, clock *lift.VirtualClock, out io.Writer) *Screen {
+	sc := &Screen{system: system, building: building, clock: clock, out: out, keys: make(chan byte),
+		quit: make(chan struct{}), quitKey: make(chan struct{})}
+	var err error
+	sc.keyboard, sc.restore, err = openKeyboard()
+	if err != nil {
+		sc.keyboard, sc.restore = nil, func() {}
+	}
+	restore := sc.restore
+	var once sync.Once
+	sc.restore = func() {
+		once.Do(func() {
+			restore()
+			fmt.Fprint(sc.out, reset+"\x1b[?25h\n") // Show the cursor.
+		})
+	}
+	fmt.Fprint(sc.out, "\x1b[2J\x1b[?25l") // Clear the screen, and hide the cursor.
+	sc.draw()
+	sc.wg.Add(1)
+	go sc.run()
+	if sc.keyboard != nil {
+		sc.wg.Add(1)
+		go sc.readKeys()
+	}
+	return sc
+}
+
+// Returns a channel which is closed when the q key is pressed. The program should then stop what it is doing (which
+// includes closing the Screen), and end.
+func (sc *Screen) Quit() <-chan struct{} { return sc.quitKey }
+
+// Draws the last frame, stops drawing and restores the terminal. Call it before closing the System.
+func (sc *Screen) Close() {
+	close(sc.quit)
+	if sc.keyboard != nil {
+		sc.keyboard.Close() // Interrupts readKeys.
+	}
+	sc.wg.Wait()
+	sc.draw()
+	sc.restore()
+}
+
+func (sc *Screen) run() {
+	defer sc.wg.Done()
+	var tick <-chan time.Time
+	for {
+		if tick == nil {
+			tick = sc.clock.After(lift.Tick)
+		}
+		select {
+		case <-tick:
+			tick = nil
+			if time.Since(sc.lastDraw) < minFrame {
+				continue
+			}
+		case key := <-sc.keys:
+			sc.onKey(key)
+		case <-sc.quit:
+			return
+		}
+		sc.draw()
+	}
+}
+
+func (sc *Screen) onKey(key byte) {
+	switch key {
+	case ' ':
+		if sc.clock.Paused() {
+			sc.clock.Resume()
+		} else {
+			sc.clock.Pause()
+		}
+	case 's', '.':
+		if !sc.clock.Paused() {
+			sc.clock.Pause()
+		}
+		sc.clock.Step(lift.Tick)
+	case '+', '=':
+		if speed := sc.clock.Speed(); speed > 0 && speed < maxSpeed {
+			sc.clock.SetSpeed(speed * 2)
+		}
+	case '-', '_':
+		if speed := sc.clock.Speed(); speed <= 0 {
+			sc.clock.SetSpeed(maxSpeed)
+		} else if speed > minSpeed {
+			sc.clock.SetSpeed(speed / 2)
+		}
+	case 'q', 'Q':
+		select {
+		case <-sc.quitKey: // Pressed already.
+		default:
+			close(sc.quitKey)
+		}
+	}
+}
+
+func (sc *Screen) readKeys() {
+	defer sc.wg.Done()
+	buf := make([]byte, 16)
+	for {
+		n, err := sc.keyboard.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range buf[:n] {
+			select {
+			case sc.keys <- key:
+			case <-sc.quit:
+				return
+			}
+		}
+	}
+}
+
+// Opens the terminal in cbreak mode: keys are read as they are pressed, without echo. We read the terminal
+// through the runtime's poller (which os.OpenFile uses for a terminal), so that Close interrupts the reader.
+// stty gets a file of its own: handing a file to a child process (see os.File.Fd) makes its reads block.
+func openKeyboard() (keyboard *os.File, restore func(), err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved, err := stty(tty, "-g")
+	if err == nil {
+		_, err = stty(tty, "cbreak", "-echo")
+	}
+	if err == nil {
+		keyboard, err = os.OpenFile("/dev/tty", os.O_RDONLY, 0)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, nil, err
+	}
+	return keyboard, func() {
+		stty(tty, strings.TrimSpace(saved))
+		tty.Close()
+	}, nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Draws a frame over the last one. Does nothing once the System is closed.
+func (sc *Screen) draw() {
+	cars, halls := sc.system.Status(), sc.system.HallCalls()
+	if cars == nil || halls == nil {
+		return
+	}
+	sc.lastDraw = time.Now()
+	waiting := make(map[lift.Floor]int)
+	lit := make(map[hallKey]bool)
+	totalWaiting, riding := 0, 0
+	for _, h := range halls {
+		waiting[h.Floor] += h.Waiting
+		lit[hallKey{h.Floor, h.Dir}] = true
+		totalWaiting += h.Waiting
+	}
+	labelWidth := 1
+	for f := lift.Floor(0); int(f) < sc.building.Floors; f++ {
+		if n := len(sc.building.Label(f)); n > labelWidth {
+			labelWidth = n
+		}
+	}
+
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString(reset + "\x1b[K\n") // Clear the rest of the line.
+	}
+	state := "running"
+	if sc.clock.Paused() {
+		state = bold + "PAUSED" + reset
+	}
+	speed := "as fast as possible"
+	if s := sc.clock.Speed(); s > 0 {
+		speed = fmt.Sprintf("%gx", s)
+	}
+	b.WriteString("\x1b[H") // Home.
+	line("%s%s%s   %s   %s, speed %s", bold, sc.building.Name, reset, sc.clock.Now().Sub(lift.Epoch).Round(lift.Tick), state, speed)
+	line("%sspace pause/resume   s step   +/- speed   q quit", dim)
+	line("")
+	header := fmt.Sprintf("%*s %-6s", labelWidth, "", "  wait")
+	for _, car := range cars {
+		header += fmt.Sprintf("│Car %-2d", car.Id)
+	}
+	line("%s│", header)
+	for f := lift.Floor(sc.building.Floors - 1); f >= 0; f-- {
+		row := fmt.Sprintf("%*s ", labelWidth, sc.building.Label(f))
+		row += sc.hallButton(f, lift.UP, lit) + sc.hallButton(f, lift.DOWN, lit)
+		if waiting[f] > 0 {
+			row += fmt.Sprintf("%4d", waiting[f])
+		} else {
+			row += "    "
+		}
+		for _, car := range cars {
+			row += "│" + carCell(car, f)
+		}
+		line("%s│", row)
+	}
+	for _, car := range cars {
+		riding += car.Load
+	}
+	line("")
+	line("%d waiting, %d riding", totalWaiting, riding)
+	b.WriteString("\x1b[J") // Clear the rest of the screen.
+	sc.out.Write(b.Bytes())
+}
+
+func (sc *Screen) hallButton(f lift.Floor, dir lift.Direction, lit map[hallKey]bool) string {
+	switch {
+	case (dir == lift.UP && int(f) == sc.building.Floors-1) || (dir == lift.DOWN && f == 0):
+		return " "
+	case lit[hallKey{f, dir}]:
+		return bold + yellow + arrows[dir] + reset
+	default:
+		return dim + arrows[dir] + reset
+	}
+}
+
+// Returns the car's cell (6 columns wide) at floor f of its shaft.
+func carCell(car lift.CarStatus, f lift.Floor) string {
+	if car.Floor != f {
+		for _, dropoff := range car.Dropoffs {
+			if dropoff == f {
+				return yellow + "  •   " + reset
+			}
+		}
+		return "      "
+	}
+	left, right, color := "[", "]", reverse
+	switch car.Doors {
+	case lift.DoorsOpening, lift.DoorsClosing:
+		left, right, color = "|", "|", onAmber
+	case lift.DoorsOpen:
+		left, right, color = "]", "[", onGreen
+	}
+	if car.Mode != lift.ModeNormal {
+		color = onBlue
+	}
+	if len(car.Faults) > 0 {
+		color = onRed
+	}
+	return fmt.Sprintf("%s%s%s%3d%s%s", color, left, arrows[car.Dir], car.Load, right, reset)
+}